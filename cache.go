@@ -2,6 +2,8 @@ package pagecache
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 )
@@ -11,6 +13,12 @@ import (
 //
 // Implementations can use various caching strategies such as in-memory,
 // file-based, or distributed caches like Redis.
+//
+// Keys are hashes, so distinct requests may share one. Implementations
+// detecting collisions record the canonical key found in the context passed
+// to Set, see WithCanonicalKey, and return ErrKeyCollision from Get when the
+// context carries a different one. Entries stored, or looked up, without a
+// canonical key are never reported as collisions.
 type Cache interface {
 	// Get retrieves an *http.Response from the cache associated with the given
 	// key.
@@ -29,3 +37,242 @@ type Cache interface {
 	// Purge clears the entire cache.
 	Purge(ctx context.Context) error
 }
+
+// Tagger is implemented by Cache implementations able to invalidate entries by
+// tag. Implementations record the tags returned by Policy.Tags when an entry
+// is stored.
+type Tagger interface {
+	// PurgeTags removes every cache entry associated with any of the given
+	// tags.
+	PurgeTags(ctx context.Context, tags ...string) error
+}
+
+// PurgeTags removes every entry associated with any of the given tags from the
+// given cache. It returns ErrUnsupported if the cache does not implement
+// Tagger.
+func PurgeTags(ctx context.Context, cache Cache, tags ...string) error {
+	tagger, ok := cache.(Tagger)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	if err := tagger.PurgeTags(ctx, tags...); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// URLPurger is implemented by Cache implementations able to remove every entry
+// stored for a URL, regardless of the method, headers, or credentials of the
+// request that generated it. Implementations index entries by the URL of the
+// request associated with the stored response, normalized by NormalizeURL.
+type URLPurger interface {
+	// PurgeURL removes every cache entry stored for the given normalized URL.
+	PurgeURL(ctx context.Context, url string) error
+}
+
+// MatchPurger is implemented by Cache implementations able to remove every
+// entry whose URL matches a URLMatcher.
+type MatchPurger interface {
+	// PurgeMatching removes every cache entry whose URL matches the given
+	// matcher.
+	PurgeMatching(ctx context.Context, matcher URLMatcher) error
+}
+
+// Lister is implemented by Cache implementations able to iterate over their
+// entries.
+type Lister interface {
+	// Range calls fn for each entry in the cache, in no particular order,
+	// until fn returns false. The cache may be modified while iterating,
+	// including from fn.
+	Range(ctx context.Context, fn func(info EntryInfo) bool) error
+}
+
+// Inspector is implemented by Cache implementations able to describe a single
+// entry without decoding its response.
+type Inspector interface {
+	// Inspect returns the metadata of the entry associated with the given
+	// key, or ErrCacheMiss if there is none.
+	Inspect(ctx context.Context, key string) (EntryInfo, error)
+}
+
+// Sizer is implemented by Cache implementations able to report their size.
+type Sizer interface {
+	// Len returns the number of entries in the cache.
+	Len(ctx context.Context) (int, error)
+
+	// Bytes returns the total size of the entries in the cache, in bytes.
+	Bytes(ctx context.Context) (uint64, error)
+}
+
+// Stats holds the counters of a cache since its creation.
+type Stats struct {
+	// Hits is the number of lookups that found a fresh entry.
+	Hits uint64
+
+	// Misses is the number of lookups that did not find a fresh entry,
+	// including lookups of expired entries.
+	Misses uint64
+
+	// Evictions is the number of entries removed to make room for others.
+	Evictions uint64
+
+	// Expirations is the number of entries removed because they expired.
+	Expirations uint64
+
+	// StoreFailures is the number of entries that could not be stored.
+	StoreFailures uint64
+}
+
+// Stater is implemented by Cache implementations able to report usage
+// statistics.
+type Stater interface {
+	// Stats returns the cache counters.
+	Stats(ctx context.Context) (Stats, error)
+}
+
+// RevalidationRecorder is implemented by Cache implementations, such as
+// metrics wrappers, that record when Transport revalidates a stored response
+// with the origin: when it forwards a request because the stored response
+// expired, to replace it, and when it updates a stored response from the
+// response to a HEAD request.
+type RevalidationRecorder interface {
+	// RecordRevalidation records a stored response being revalidated for
+	// the request carried by the given context, see WithRequest.
+	RecordRevalidation(ctx context.Context)
+}
+
+// RecordRevalidation records a stored response being revalidated for the
+// request carried by the given context in the given cache. It returns
+// ErrUnsupported if the cache does not implement RevalidationRecorder.
+func RecordRevalidation(ctx context.Context, cache Cache) error {
+	recorder, ok := cache.(RevalidationRecorder)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	recorder.RecordRevalidation(ctx)
+
+	return nil
+}
+
+// Range calls fn for each entry in the given cache until fn returns false. It
+// returns ErrUnsupported if the cache does not implement Lister.
+func Range(ctx context.Context, cache Cache, fn func(info EntryInfo) bool) error {
+	lister, ok := cache.(Lister)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	if err := lister.Range(ctx, fn); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// Inspect returns the metadata of the entry associated with the given key in
+// the given cache. It returns ErrUnsupported if the cache does not implement
+// Inspector.
+func Inspect(ctx context.Context, cache Cache, key string) (EntryInfo, error) {
+	inspector, ok := cache.(Inspector)
+	if !ok {
+		return EntryInfo{}, ErrUnsupported
+	}
+
+	info, err := inspector.Inspect(ctx, key)
+	if err != nil {
+		return EntryInfo{}, fmt.Errorf("%w", err)
+	}
+
+	return info, nil
+}
+
+// Len returns the number of entries in the given cache. It returns
+// ErrUnsupported if the cache does not implement Sizer.
+func Len(ctx context.Context, cache Cache) (int, error) {
+	sizer, ok := cache.(Sizer)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+
+	n, err := sizer.Len(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+// Bytes returns the total size of the entries in the given cache, in bytes. It
+// returns ErrUnsupported if the cache does not implement Sizer.
+func Bytes(ctx context.Context, cache Cache) (uint64, error) {
+	sizer, ok := cache.(Sizer)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+
+	n, err := sizer.Bytes(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+// ReadStats returns the counters of the given cache. It returns
+// ErrUnsupported if the cache does not implement Stater.
+func ReadStats(ctx context.Context, cache Cache) (Stats, error) {
+	stater, ok := cache.(Stater)
+	if !ok {
+		return Stats{}, ErrUnsupported
+	}
+
+	stats, err := stater.Stats(ctx)
+	if err != nil {
+		return Stats{}, fmt.Errorf("%w", err)
+	}
+
+	return stats, nil
+}
+
+// PurgeMatching removes every entry whose URL matches the given matcher from
+// the given cache. If the cache does not implement MatchPurger, it falls back
+// to iterating over its entries if it implements Lister, and returns
+// ErrUnsupported otherwise.
+func PurgeMatching(ctx context.Context, cache Cache, matcher URLMatcher) error {
+	if purger, ok := cache.(MatchPurger); ok {
+		if err := purger.PurgeMatching(ctx, matcher); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		return nil
+	}
+
+	lister, ok := cache.(Lister)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	var keys []string
+
+	err := lister.Range(ctx, func(info EntryInfo) bool {
+		if matcher.MatchURL(info.URL) {
+			keys = append(keys, info.Key)
+		}
+
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	for _, key := range keys {
+		if err := cache.Delete(ctx, key); err != nil && !errors.Is(err, ErrCacheMiss) {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package pagecache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+func TestCapabilityHelpers_Unsupported(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		cache = pagecache.NewNamespace(nil, "test", 0)
+	)
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{
+			name: "Range",
+			call: func() error {
+				return pagecache.Range(ctx, cache, func(pagecache.EntryInfo) bool { return true })
+			},
+		},
+		{
+			name: "Inspect",
+			call: func() error {
+				_, err := pagecache.Inspect(ctx, cache, "key")
+
+				return err
+			},
+		},
+		{
+			name: "Len",
+			call: func() error {
+				_, err := pagecache.Len(ctx, cache)
+
+				return err
+			},
+		},
+		{
+			name: "Bytes",
+			call: func() error {
+				_, err := pagecache.Bytes(ctx, cache)
+
+				return err
+			},
+		},
+		{
+			name: "ReadStats",
+			call: func() error {
+				_, err := pagecache.ReadStats(ctx, cache)
+
+				return err
+			},
+		},
+		{
+			name: "PurgeMatching",
+			call: func() error {
+				return pagecache.PurgeMatching(ctx, cache, pagecache.MatchHost("example.com"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tt.call(); !errors.Is(err, pagecache.ErrUnsupported) {
+				t.Errorf("%s() = %v, want %v", tt.name, err, pagecache.ErrUnsupported)
+			}
+		})
+	}
+}
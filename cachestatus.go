@@ -0,0 +1,190 @@
+package pagecache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheStatusHeader is the name of the header describing how caches handled a
+// request, as defined by RFC 9211.
+const CacheStatusHeader = "Cache-Status"
+
+// Forward reasons of the fwd parameter of the Cache-Status header, as defined
+// by RFC 9211, Section 2.2.
+const (
+	// FwdBypass means the cache was configured to not handle the request.
+	FwdBypass = "bypass"
+
+	// FwdMethod means the request method's semantics require it to be
+	// forwarded.
+	FwdMethod = "method"
+
+	// FwdURIMiss means the cache did not contain any response matching the
+	// request URI.
+	FwdURIMiss = "uri-miss"
+
+	// FwdVaryMiss means the cache contained a response matching the request
+	// URI, but it could not select one for the request.
+	FwdVaryMiss = "vary-miss"
+
+	// FwdMiss means the cache did not contain any usable response, for an
+	// unknown reason.
+	FwdMiss = "miss"
+
+	// FwdRequest means the cache was able to select a response, but the
+	// request semantics, such as Cache-Control: no-cache, required forwarding.
+	FwdRequest = "request"
+
+	// FwdStale means the cache was able to select a response, but it was
+	// stale.
+	FwdStale = "stale"
+)
+
+// CacheStatus describes how a cache handled a request, and is serialized as a
+// member of the Cache-Status header list defined by RFC 9211.
+type CacheStatus struct {
+	// TTL is the remaining freshness lifetime of the response, if known.
+	TTL *time.Duration
+
+	// Name identifies the cache.
+	Name string
+
+	// Fwd is the reason the request was forwarded towards the origin, or
+	// empty if it was not.
+	Fwd string
+
+	// Key is the cache key of the response.
+	Key string
+
+	// Detail holds implementation-specific information.
+	Detail string
+
+	// FwdStatus is the status code of the response received from the next
+	// hop when the request was forwarded, or zero.
+	FwdStatus int
+
+	// Hit reports whether the request was satisfied by the cache.
+	Hit bool
+
+	// Stored reports whether the forwarded response was stored in the cache.
+	// Responses with a body are stored once the client has read it
+	// completely, after Cache-Status is sent, so it is never set for them.
+	Stored bool
+}
+
+// String returns the Cache-Status list member describing the status, in the
+// structured field syntax of RFC 8941.
+func (cs *CacheStatus) String() string {
+	var b strings.Builder
+
+	writeSFItem(&b, cs.Name)
+
+	if cs.Hit {
+		b.WriteString("; hit")
+	}
+
+	if cs.Fwd != "" {
+		b.WriteString("; fwd=")
+		b.WriteString(cs.Fwd)
+
+		if cs.FwdStatus != 0 {
+			b.WriteString("; fwd-status=")
+			b.WriteString(strconv.Itoa(cs.FwdStatus))
+		}
+	}
+
+	if cs.TTL != nil {
+		b.WriteString("; ttl=")
+		b.WriteString(strconv.FormatInt(int64(cs.TTL.Seconds()), 10))
+	}
+
+	if cs.Stored {
+		b.WriteString("; stored")
+	}
+
+	if cs.Key != "" {
+		b.WriteString("; key=")
+		writeSFString(&b, cs.Key)
+	}
+
+	if cs.Detail != "" {
+		b.WriteString("; detail=")
+		writeSFItem(&b, cs.Detail)
+	}
+
+	return b.String()
+}
+
+// AddCacheStatus appends the given status to the Cache-Status header, after
+// the members added by caches closer to the origin, preserving their order.
+func AddCacheStatus(header http.Header, status *CacheStatus) {
+	header.Add(CacheStatusHeader, status.String())
+}
+
+// writeSFItem writes the given value as a structured field token if it is a
+// valid one, and as a string otherwise.
+func writeSFItem(b *strings.Builder, value string) {
+	if isSFToken(value) {
+		b.WriteString(value)
+
+		return
+	}
+
+	writeSFString(b, value)
+}
+
+// writeSFString writes the given value as a structured field string. Characters
+// not allowed in strings are dropped.
+func writeSFString(b *strings.Builder, value string) {
+	b.WriteByte('"')
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x20 && c <= 0x7e:
+			b.WriteByte(c)
+		}
+	}
+
+	b.WriteByte('"')
+}
+
+// isSFToken reports whether the given value is a valid structured field token,
+// as defined by RFC 8941, Section 3.3.4.
+func isSFToken(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	if c := value[0]; c != '*' && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+		return false
+	}
+
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+
+		if c == ':' || c == '/' || isTokenChar(c) {
+			continue
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// isTokenChar reports whether the given character is a tchar, as defined by
+// RFC 9110, Section 5.6.2.
+func isTokenChar(c byte) bool {
+	if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+		return true
+	}
+
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,77 @@
+package pagecache_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+func TestCacheStatus_String(t *testing.T) {
+	t.Parallel()
+
+	ttl := 90*time.Second + 500*time.Millisecond
+
+	tests := []struct {
+		name   string
+		status *pagecache.CacheStatus
+		want   string
+	}{
+		{
+			name:   "Hit",
+			status: &pagecache.CacheStatus{Name: "httpx", Hit: true, TTL: &ttl, Key: "abc"},
+			want:   `httpx; hit; ttl=90; key="abc"`,
+		},
+		{
+			name:   "Miss stored",
+			status: &pagecache.CacheStatus{Name: "httpx", Fwd: pagecache.FwdURIMiss, FwdStatus: 200, Stored: true, TTL: &ttl},
+			want:   `httpx; fwd=uri-miss; fwd-status=200; ttl=90; stored`,
+		},
+		{
+			name:   "Name not a token",
+			status: &pagecache.CacheStatus{Name: `My "Cache"`, Fwd: pagecache.FwdBypass},
+			want:   `"My \"Cache\""; fwd=bypass`,
+		},
+		{
+			name:   "Detail",
+			status: &pagecache.CacheStatus{Name: "httpx", Fwd: pagecache.FwdStale, Detail: "expired"},
+			want:   `httpx; fwd=stale; detail=expired`,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.status.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddCacheStatus(t *testing.T) {
+	t.Parallel()
+
+	header := http.Header{}
+	header.Add(pagecache.CacheStatusHeader, "Origin; hit")
+	header.Add(pagecache.CacheStatusHeader, `"CDN"; fwd=uri-miss`)
+
+	pagecache.AddCacheStatus(header, &pagecache.CacheStatus{Name: "httpx", Hit: true})
+
+	want := []string{"Origin; hit", `"CDN"; fwd=uri-miss`, "httpx; hit"}
+
+	got := header.Values(pagecache.CacheStatusHeader)
+	if len(got) != len(want) {
+		t.Fatalf("Cache-Status = %q, want %q", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Cache-Status[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
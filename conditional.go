@@ -0,0 +1,153 @@
+package pagecache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// notModifiedHeaders are the header fields of a stored response kept in a 304
+// response, following RFC 9110, Section 15.4.5. Last-Modified is kept to help
+// clients update their caches, and Cache-Status to keep the members added by
+// caches closer to the origin.
+var notModifiedHeaders = []string{ //nolint:gochecknoglobals // read-only list
+	"Cache-Control",
+	"Cache-Status",
+	"Content-Location",
+	"Date",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+	"Vary",
+}
+
+// isWeakETag reports whether the given entity tag is weak.
+func isWeakETag(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+// matchETag compares two entity tags, as defined by RFC 9110, Section 8.8.3.2.
+// The strong comparison requires both to be strong and identical, while the
+// weak comparison ignores the weakness indicator.
+func matchETag(a, b string, weak bool) bool {
+	if a == "" || b == "" {
+		return false
+	}
+
+	if weak {
+		return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+	}
+
+	return !isWeakETag(a) && !isWeakETag(b) && a == b
+}
+
+// splitETags splits a list of entity tags, such as the value of If-None-Match,
+// into its members. Commas inside entity tags are preserved.
+func splitETags(value string) []string {
+	var (
+		etags   []string
+		start   int
+		quoted  bool
+		trimmed = strings.TrimSpace(value)
+	)
+
+	for i := 0; i < len(trimmed); i++ {
+		switch trimmed[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				if etag := strings.TrimSpace(trimmed[start:i]); etag != "" {
+					etags = append(etags, etag)
+				}
+
+				start = i + 1
+			}
+		}
+	}
+
+	if etag := strings.TrimSpace(trimmed[start:]); etag != "" {
+		etags = append(etags, etag)
+	}
+
+	return etags
+}
+
+// notModified reports whether the conditional headers of the given request
+// match the given stored response, so that a 304 response can be sent
+// instead of it, following RFC 9111, Section 4.3.2.
+//
+// Only If-None-Match and If-Modified-Since are evaluated, since the other
+// preconditions apply to origin servers. If-None-Match uses the weak
+// comparison and takes precedence over If-Modified-Since, which compares
+// against the Last-Modified date of the response, or its Date if it has
+// none.
+//
+// Preconditions are only evaluated against successful responses, following
+// RFC 9110, Section 13.2.1, so stored error responses, such as negatively
+// cached 404 responses, are always served as is.
+func notModified(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return false
+	}
+
+	if values := req.Header.Values("If-None-Match"); len(values) > 0 {
+		etag := resp.Header.Get("ETag")
+
+		for _, value := range values {
+			for _, candidate := range splitETags(value) {
+				if candidate == "*" || matchETag(candidate, etag, true) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	value := req.Header.Get("If-Modified-Since")
+	if value == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(value)
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		if lastModified, err = http.ParseTime(resp.Header.Get("Date")); err != nil {
+			return false
+		}
+	}
+
+	return !lastModified.After(since)
+}
+
+// notModifiedResponse returns the 304 response to send in place of the given
+// stored response.
+func notModifiedResponse(resp *http.Response) *http.Response {
+	resp.Body.Close()
+
+	header := make(http.Header, len(notModifiedHeaders))
+
+	for _, name := range notModifiedHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+		}
+	}
+
+	notModified := *resp
+	notModified.StatusCode = http.StatusNotModified
+	notModified.Status = strconv.Itoa(http.StatusNotModified) + " " + http.StatusText(http.StatusNotModified)
+	notModified.Header = header
+	notModified.Body = http.NoBody
+	notModified.ContentLength = 0
+
+	return &notModified
+}
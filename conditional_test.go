@@ -0,0 +1,273 @@
+package pagecache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+const testLastModified = "Mon, 02 Jan 2023 15:04:05 GMT"
+
+func TestTransport_RoundTrip_Conditional(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+
+		// The origin ignores preconditions, so any 304 comes from the cache.
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", testLastModified)
+		w.Header().Set("Cache-Control", "max-age=3600")
+
+		io.WriteString(w, "0123456789") //nolint:errcheck // test server
+	}))
+	defer ts.Close()
+
+	client := &http.Client{
+		Transport: pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport),
+	}
+
+	do := func(t *testing.T, header http.Header) (*http.Response, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/page", http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header = header
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resp, string(body)
+	}
+
+	// Store the response.
+	do(t, http.Header{})
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantStatus int
+	}{
+		{
+			name:       "If-None-Match strong match",
+			header:     http.Header{"If-None-Match": {`"v1"`}},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "If-None-Match weak comparison",
+			header:     http.Header{"If-None-Match": {`W/"v1"`}},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "If-None-Match list",
+			header:     http.Header{"If-None-Match": {`"v0", "a,b", "v1"`}},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "If-None-Match wildcard",
+			header:     http.Header{"If-None-Match": {"*"}},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "If-None-Match mismatch",
+			header:     http.Header{"If-None-Match": {`"v2"`}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "If-None-Match takes precedence over If-Modified-Since",
+			header: http.Header{
+				"If-None-Match":     {`"v2"`},
+				"If-Modified-Since": {"Tue, 01 Jan 2030 00:00:00 GMT"},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "If-Modified-Since equal",
+			header:     http.Header{"If-Modified-Since": {testLastModified}},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "If-Modified-Since earlier",
+			header:     http.Header{"If-Modified-Since": {"Sun, 01 Jan 2023 00:00:00 GMT"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "If-Modified-Since invalid",
+			header:     http.Header{"If-Modified-Since": {"yesterday"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "If-Match is left to the origin",
+			header:     http.Header{"If-Match": {`"v2"`}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Precondition evaluated before Range",
+			header:     http.Header{"If-None-Match": {`"v1"`}, "Range": {"bytes=0-1"}},
+			wantStatus: http.StatusNotModified,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := do(t, tt.header)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusNotModified {
+				return
+			}
+
+			if body != "" {
+				t.Errorf("body = %q, want empty", body)
+			}
+
+			if got := resp.Header.Get("ETag"); got != `"v1"` {
+				t.Errorf("ETag = %q, want %q", got, `"v1"`)
+			}
+
+			if got := resp.Header.Get("Cache-Control"); got != "max-age=3600" {
+				t.Errorf("Cache-Control = %q, want %q", got, "max-age=3600")
+			}
+
+			if got := resp.Header.Get("Content-Type"); got != "" {
+				t.Errorf("Content-Type = %q, want none", got)
+			}
+		})
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("origin calls = %d, want 1", got)
+	}
+}
+
+func TestTransport_RoundTrip_ConditionalNotFound(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+
+		w.Header().Set("ETag", `"missing"`)
+		w.Header().Set("Last-Modified", testLastModified)
+		w.WriteHeader(http.StatusNotFound)
+
+		io.WriteString(w, "not found") //nolint:errcheck // test server
+	}))
+	defer ts.Close()
+
+	policy := pagecache.DefaultPolicy()
+	policy.StatusTTLs[http.StatusNotFound] = time.Minute
+
+	client := &http.Client{
+		Transport: pagecache.NewTransport(memorycachex.NewCache(policy, 0), ts.Client().Transport),
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{
+			name:   "Store",
+			header: http.Header{},
+		},
+		{
+			name:   "If-None-Match wildcard",
+			header: http.Header{"If-None-Match": {"*"}},
+		},
+		{
+			name:   "If-None-Match match",
+			header: http.Header{"If-None-Match": {`"missing"`}},
+		},
+		{
+			name:   "If-Modified-Since",
+			header: http.Header{"If-Modified-Since": {testLastModified}},
+		},
+	}
+
+	// The cases don't run in parallel, since the first one stores the
+	// response the others are served.
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/missing", http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header = tt.header
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != http.StatusNotFound || string(body) != "not found" {
+				t.Errorf("response = %d %q, want %d %q", resp.StatusCode, body, http.StatusNotFound, "not found")
+			}
+		})
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("origin calls = %d, want 1", got)
+	}
+}
+
+func TestMiddleware_ServeHTTP_Conditional(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `W/"v1"`)
+
+		io.WriteString(w, "page") //nolint:errcheck // test handler
+	})
+
+	middleware := pagecache.NewMiddleware(memorycachex.NewCache(nil, 0), handler)
+
+	for _, want := range []int{http.StatusOK, http.StatusNotModified} {
+		req := httptest.NewRequest(http.MethodGet, "/page", http.NoBody)
+		req.Header.Set("If-None-Match", `W/"v1"`)
+
+		rec := httptest.NewRecorder()
+
+		middleware.ServeHTTP(rec, req)
+
+		// The first request is forwarded to the handler, which ignores
+		// preconditions; the second one is answered from the cache.
+		if rec.Code != want {
+			t.Errorf("status = %d, want %d", rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package pagecache
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKey is the type of the keys used to store values in a context.
+type contextKey int
+
+const (
+	// canonicalKeyContextKey is the context key for the canonical form of a
+	// cache key.
+	canonicalKeyContextKey contextKey = iota
+
+	// principalContextKey is the context key for the principal a request is
+	// made on behalf of.
+	principalContextKey
+
+	// requestContextKey is the context key for the request a cache operation
+	// is made for.
+	requestContextKey
+
+	// segmentsContextKey is the context key marking operations on stored
+	// segments.
+	segmentsContextKey
+)
+
+// WithCanonicalKey returns a copy of the given context carrying the canonical,
+// pre-hash form of the cache key an operation refers to.
+//
+// Cache implementations that store it alongside their entries can detect hash
+// collisions by comparing it on lookup, and treat a mismatch as a miss
+// instead of serving the wrong response.
+func WithCanonicalKey(ctx context.Context, canonical string) context.Context {
+	return context.WithValue(ctx, canonicalKeyContextKey, canonical)
+}
+
+// CanonicalKeyFromContext returns the canonical form of the cache key carried
+// by the given context, if any.
+func CanonicalKeyFromContext(ctx context.Context) (string, bool) {
+	canonical, ok := ctx.Value(canonicalKeyContextKey).(string)
+
+	return canonical, ok
+}
+
+// WithPrincipal returns a copy of the given context carrying the identity of
+// the principal, such as a user ID, a request is made on behalf of.
+//
+// Cache keys generated for requests using the returned context are
+// partitioned by principal, just like for requests carrying credentials. See
+// Credentials for details.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// PrincipalFromContext returns the principal carried by the given context, if
+// any.
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalContextKey).(string)
+
+	return principal, ok
+}
+
+// WithRequest returns a copy of the given context carrying the request a
+// cache operation is made for, so that Cache wrappers can inspect it.
+func WithRequest(ctx context.Context, req *http.Request) context.Context {
+	return context.WithValue(ctx, requestContextKey, req)
+}
+
+// RequestFromContext returns the request carried by the given context, if
+// any.
+func RequestFromContext(ctx context.Context) (*http.Request, bool) {
+	req, ok := ctx.Value(requestContextKey).(*http.Request)
+
+	return req, ok && req != nil
+}
+
+// WithSegments returns a copy of the given context marking the cache
+// operation as one on the partial responses Transport combines and stores as
+// segments, under a key of their own.
+//
+// Partial responses don't hold the complete representation, so Cache
+// implementations should refuse to store 206 responses unless the context is
+// marked, since they would be served in place of the complete response.
+func WithSegments(ctx context.Context) context.Context {
+	return context.WithValue(ctx, segmentsContextKey, true)
+}
+
+// SegmentsFromContext reports whether the given context marks an operation on
+// stored segments, see WithSegments.
+func SegmentsFromContext(ctx context.Context) bool {
+	segments, _ := ctx.Value(segmentsContextKey).(bool)
+
+	return segments
+}
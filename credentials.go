@@ -0,0 +1,164 @@
+package pagecache
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"git.sr.ht/~jamesponddotco/xstd-go/xerrors"
+)
+
+const (
+	// ErrCredentialsSecretEmpty is returned when setting an empty credentials
+	// secret.
+	ErrCredentialsSecretEmpty xerrors.Error = "credentials secret is empty"
+
+	// ErrCredentialsSecretUnavailable is returned when no credentials secret
+	// was set and a random one can't be generated.
+	ErrCredentialsSecretUnavailable xerrors.Error = "credentials secret unavailable"
+)
+
+// CredentialsUnavailable is the identity returned by Credentials for requests
+// carrying credentials when their identity can't be computed safely, because
+// no credentials secret was set and a random one can't be generated. Such
+// requests are never cached, whatever the CredentialMode.
+const CredentialsUnavailable = "unavailable"
+
+// credentialsSecretSize is the size of the random credentials secret, in
+// bytes.
+const credentialsSecretSize = 32
+
+// _credentialsSecret keys the HMAC of credential identities. It is random for
+// each process unless set with SetCredentialsSecret.
+var _credentialsSecret atomic.Pointer[[]byte] //nolint:gochecknoglobals // process-wide secret
+
+const (
+	// CredentialsPartition means requests carrying credentials are cached
+	// separately for each credential identity, so different users never share
+	// an entry.
+	CredentialsPartition CredentialMode = iota
+
+	// CredentialsRefuse means requests carrying credentials are never cached.
+	CredentialsRefuse
+)
+
+// CredentialMode represents how requests carrying credentials are cached.
+type CredentialMode int
+
+// Credentials returns an opaque identity for the credentials carried by the
+// given request, or an empty string if it carries none.
+//
+// Credentials are taken from the principal stored in the request's context by
+// WithPrincipal, the URL's userinfo, and the Authorization header. They are
+// hashed with HMAC-SHA-256, keyed with the secret set by SetCredentialsSecret,
+// so the identity can be used in cache keys without exposing secrets, and
+// without letting anyone able to list keys guess weak passwords offline.
+//
+// If the secret can't be generated, CredentialsUnavailable is returned for
+// requests carrying credentials.
+func Credentials(req *http.Request) string {
+	var builder strings.Builder
+
+	if principal, ok := PrincipalFromContext(req.Context()); ok && principal != "" {
+		writeCredentialsPart(&builder, "principal", principal)
+	}
+
+	if req.URL != nil && req.URL.User != nil {
+		writeCredentialsPart(&builder, "userinfo", req.URL.User.String())
+	}
+
+	if authorization := req.Header.Get("Authorization"); authorization != "" {
+		writeCredentialsPart(&builder, "authorization", authorization)
+	}
+
+	if builder.Len() == 0 {
+		return ""
+	}
+
+	secret, err := credentialsSecret()
+	if err != nil {
+		return CredentialsUnavailable
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(builder.String())) //nolint:errcheck // hash.Hash.Write never returns an error
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// writeCredentialsPart writes the given named part of a credential identity to
+// the given builder, prefixed with its length, so that a part can't be crafted
+// to look like several ones.
+func writeCredentialsPart(builder *strings.Builder, name, value string) {
+	builder.WriteString(name)
+	builder.WriteByte(':')
+	builder.WriteString(strconv.Itoa(len(value)))
+	builder.WriteByte(':')
+	builder.WriteString(value)
+}
+
+// isCacheableCredentials reports whether a request with the given credential
+// identity may be cached in the given mode.
+func isCacheableCredentials(mode CredentialMode, identity string) bool {
+	return identity == "" || (mode != CredentialsRefuse && identity != CredentialsUnavailable)
+}
+
+// SetCredentialsSecret sets the secret keying the hash of the credential
+// identities returned by Credentials, and so the cache keys of requests
+// carrying credentials.
+//
+// By default, a random secret is generated for each process, so entries stored
+// for requests carrying credentials are not found again after a restart, nor
+// by other processes sharing the same cache. Processes meant to share such
+// entries must set the same secret, which must be kept private, before
+// handling any request.
+func SetCredentialsSecret(secret []byte) error {
+	if len(secret) == 0 {
+		return ErrCredentialsSecretEmpty
+	}
+
+	key := append([]byte(nil), secret...)
+
+	_credentialsSecret.Store(&key)
+
+	return nil
+}
+
+// credentialsSecret returns the secret keying the hash of credential
+// identities, generating a random one if none was set. It returns
+// ErrCredentialsSecretUnavailable if generating it fails, which crypto/rand
+// only does on broken systems, and tries again on the next call.
+func credentialsSecret() ([]byte, error) {
+	if key := _credentialsSecret.Load(); key != nil {
+		return *key, nil
+	}
+
+	key := make([]byte, credentialsSecretSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCredentialsSecretUnavailable, err)
+	}
+
+	_credentialsSecret.CompareAndSwap(nil, &key)
+
+	return *_credentialsSecret.Load(), nil
+}
+
+// withCredentials returns the given extra information with the credential
+// identity of the given request added to it, if any.
+func withCredentials(req *http.Request, extra []string) []string {
+	identity := Credentials(req)
+	if identity == "" {
+		return extra
+	}
+
+	values := make([]string, 0, len(extra)+1)
+	values = append(values, extra...)
+
+	return append(values, "credentials:"+identity)
+}
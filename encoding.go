@@ -0,0 +1,265 @@
+package pagecache
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content codings supported by Transport.StoreEncoded, as defined by RFC 9110,
+// Section 8.4.1.
+const (
+	codingGzip    = "gzip"
+	codingDeflate = "deflate"
+)
+
+// encodedRequest returns the request to forward to the origin for the given
+// request when responses are stored encoded: a copy asking for a gzip or
+// deflate encoded response, whatever the client accepts. Range requests are
+// forwarded as is, since partial responses can't be decoded.
+func (t *Transport) encodedRequest(req *http.Request) *http.Request {
+	if !t.StoreEncoded || req.Header.Get("Range") != "" {
+		return req
+	}
+
+	// Setting Accept-Encoding also stops http.Transport from decoding gzip
+	// responses itself.
+	encoded := req.Clone(req.Context())
+	encoded.Header.Set("Accept-Encoding", codingGzip+", "+codingDeflate)
+
+	return encoded
+}
+
+// decodeResponse prepares the given response, which may be stored encoded, to
+// be returned for the given request when responses are stored encoded. Its
+// body is decoded on the fly if the client doesn't accept its content coding,
+// dropping Content-Encoding and Content-Length and weakening its entity tag,
+// since the decoded body is a different representation. Accept-Encoding is
+// added to Vary either way, as the response depends on it.
+//
+// Responses are never encoded, so they can't be encoded twice. Responses with
+// no content coding, with several ones, or with one other than gzip or
+// deflate are returned as is.
+func (t *Transport) decodeResponse(req *http.Request, resp *http.Response) *http.Response {
+	if !t.StoreEncoded {
+		return resp
+	}
+
+	coding := contentCoding(resp.Header)
+	if coding == "" {
+		return resp
+	}
+
+	addVary(resp.Header, "Accept-Encoding")
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent, resp.StatusCode == http.StatusNotModified:
+		return resp
+	case acceptsEncoding(req.Header, coding):
+		return resp
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	if etag := resp.Header.Get("ETag"); etag != "" && !isWeakETag(etag) {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+
+	if resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = &decodedBody{raw: resp.Body, coding: coding}
+	}
+
+	return resp
+}
+
+// decodedBody is an io.ReadCloser decoding a gzip or deflate encoded body as
+// it is read.
+type decodedBody struct {
+	// raw is the encoded body.
+	raw io.ReadCloser
+
+	// decoder reads the decoded body. It is created by the first call to
+	// Read, since creating it reads from raw.
+	decoder io.Reader
+
+	// err is the error returned when creating decoder, if any.
+	err error
+
+	// coding is the content coding of raw.
+	coding string
+}
+
+// Compile-time check to ensure decodedBody implements the io.ReadCloser
+// interface.
+var _ io.ReadCloser = (*decodedBody)(nil)
+
+// Read implements the io.Reader interface.
+func (db *decodedBody) Read(p []byte) (int, error) {
+	if db.decoder == nil && db.err == nil {
+		db.decoder, db.err = newDecoder(db.coding, db.raw)
+	}
+
+	if db.err != nil {
+		return 0, db.err
+	}
+
+	n, err := db.decoder.Read(p)
+	if errors.Is(err, io.EOF) {
+		// Decoders may stop before the end of the encoded body, which must
+		// be read completely for the response to be stored.
+		if _, err := io.Copy(io.Discard, db.raw); err != nil {
+			return n, fmt.Errorf("%w", err)
+		}
+
+		return n, io.EOF
+	}
+
+	if err != nil {
+		return n, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+// Close implements the io.Closer interface.
+func (db *decodedBody) Close() error {
+	return db.raw.Close() //nolint:wrapcheck // errors of the original body are returned as is
+}
+
+// newDecoder returns a reader decoding the given body with the given content
+// coding. The deflate coding is the zlib format, as defined by RFC 9110,
+// Section 8.4.1.2, but raw deflate streams, which some servers send instead,
+// are accepted too.
+func newDecoder(coding string, body io.Reader) (io.Reader, error) {
+	if coding == codingGzip {
+		decoder, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		return decoder, nil
+	}
+
+	buffered := bufio.NewReader(body)
+
+	header, err := buffered.Peek(2)
+	if err != nil || !isZlibHeader(header) {
+		return flate.NewReader(buffered), nil
+	}
+
+	decoder, err := zlib.NewReader(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return decoder, nil
+}
+
+// isZlibHeader reports whether the given two bytes are a valid zlib header,
+// as defined by RFC 1950, Section 2.2.
+func isZlibHeader(header []byte) bool {
+	const deflateMethod = 8
+
+	return header[0]&0x0f == deflateMethod && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+// contentCoding returns the content coding of a response with the given
+// header if it has exactly one, and it is gzip or deflate, or an empty string
+// otherwise.
+func contentCoding(header http.Header) string {
+	var codings []string
+
+	for _, value := range header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+
+	if len(codings) != 1 {
+		return ""
+	}
+
+	switch codings[0] {
+	case codingGzip, "x-gzip":
+		return codingGzip
+	case codingDeflate:
+		return codingDeflate
+	default:
+		return ""
+	}
+}
+
+// acceptsEncoding reports whether a request with the given header accepts the
+// given content coding, following RFC 9110, Section 12.5.3. Requests without
+// Accept-Encoding are assumed to only accept unencoded responses, like most
+// clients sending none expect.
+func acceptsEncoding(header http.Header, coding string) bool {
+	var wildcard bool
+
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, member := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(member, ";")
+
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "x-gzip" {
+				name = codingGzip
+			}
+
+			switch name {
+			case coding:
+				return qvalue(params) > 0
+			case "*":
+				wildcard = qvalue(params) > 0
+			}
+		}
+	}
+
+	return wildcard
+}
+
+// qvalue returns the quality value of the given parameters of an
+// Accept-Encoding member, as defined by RFC 9110, Section 12.4.2. It returns 1
+// if there is no valid quality value.
+func qvalue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 1
+		}
+
+		return q
+	}
+
+	return 1
+}
+
+// addVary adds the given request header to the Vary header, unless it is
+// already listed or Vary is "*".
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, member := range strings.Split(value, ",") {
+			if member = strings.TrimSpace(member); member == "*" || strings.EqualFold(member, name) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", name)
+}
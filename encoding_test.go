@@ -0,0 +1,337 @@
+package pagecache_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+// encode returns the given body encoded with the given content coding, where
+// "raw-deflate" is a deflate stream without the zlib wrapper.
+func encode(t *testing.T, coding, body string) []byte {
+	t.Helper()
+
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+		err error
+	)
+
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return []byte(body)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.WriteString(w, body); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestTransport_RoundTrip_StoreEncoded(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat("page ", 100)
+
+	tests := []struct {
+		name string
+		// coding is the content coding the origin uses, whatever the request
+		// accepts, and header is its Content-Encoding value.
+		coding string
+		header string
+		// acceptEncoding is the Accept-Encoding header of the client.
+		acceptEncoding string
+		wantEncoding   string
+		wantDecoded    bool
+	}{
+		{
+			name:           "Gzip accepted",
+			coding:         "gzip",
+			header:         "gzip",
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+		},
+		{
+			name:        "Gzip without Accept-Encoding",
+			coding:      "gzip",
+			header:      "gzip",
+			wantDecoded: true,
+		},
+		{
+			name:           "Gzip refused",
+			coding:         "gzip",
+			header:         "gzip",
+			acceptEncoding: "deflate, gzip;q=0",
+			wantDecoded:    true,
+		},
+		{
+			name:           "Gzip accepted through wildcard",
+			coding:         "gzip",
+			header:         "gzip",
+			acceptEncoding: "br, *;q=0.5",
+			wantEncoding:   "gzip",
+		},
+		{
+			name:           "Deflate refused",
+			coding:         "deflate",
+			header:         "deflate",
+			acceptEncoding: "gzip",
+			wantDecoded:    true,
+		},
+		{
+			name:        "Raw deflate refused",
+			coding:      "raw-deflate",
+			header:      "deflate",
+			wantDecoded: true,
+		},
+		{
+			name:         "Unsupported coding",
+			coding:       "",
+			header:       "br",
+			wantEncoding: "br",
+		},
+		{
+			name:        "Identity",
+			coding:      "",
+			wantDecoded: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				calls   atomic.Int64
+				encoded = encode(t, tt.coding, body)
+			)
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+
+				if got := r.Header.Get("Accept-Encoding"); got != "gzip, deflate" {
+					t.Errorf("origin Accept-Encoding = %q, want %q", got, "gzip, deflate")
+				}
+
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("ETag", `"v1"`)
+
+				if tt.header != "" {
+					w.Header().Set("Content-Encoding", tt.header)
+				}
+
+				w.Write(encoded) //nolint:errcheck // test server
+			}))
+			defer ts.Close()
+
+			transport := pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport)
+			transport.StoreEncoded = true
+
+			client := &http.Client{Transport: transport}
+
+			do := func(acceptEncoding string) (*http.Response, []byte) {
+				t.Helper()
+
+				req, err := http.NewRequest(http.MethodGet, ts.URL, http.NoBody)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if acceptEncoding != "" {
+					req.Header.Set("Accept-Encoding", acceptEncoding)
+				}
+
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("Do() unexpected error: %v", err)
+				}
+				defer resp.Body.Close()
+
+				got, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatalf("ReadAll() unexpected error: %v", err)
+				}
+
+				return resp, got
+			}
+
+			// The first request stores the response as sent by the origin.
+			do("gzip, deflate")
+
+			resp, got := do(tt.acceptEncoding)
+
+			if n := calls.Load(); n != 1 {
+				t.Errorf("origin calls = %d, want 1", n)
+			}
+
+			if enc := resp.Header.Get("Content-Encoding"); enc != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", enc, tt.wantEncoding)
+			}
+
+			want := encoded
+			if tt.wantDecoded {
+				want = []byte(body)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("body has %d bytes, want %d", len(got), len(want))
+			}
+
+			wantVary := ""
+			if tt.coding != "" {
+				wantVary = "Accept-Encoding"
+			}
+
+			if vary := resp.Header.Get("Vary"); vary != wantVary {
+				t.Errorf("Vary = %q, want %q", vary, wantVary)
+			}
+
+			if tt.wantDecoded && tt.coding != "" {
+				if length := resp.Header.Get("Content-Length"); length != "" {
+					t.Errorf("Content-Length = %q, want none", length)
+				}
+
+				if etag := resp.Header.Get("ETag"); etag != `W/"v1"` {
+					t.Errorf("ETag = %q, want %q", etag, `W/"v1"`)
+				}
+			}
+		})
+	}
+}
+
+func TestTransport_RoundTrip_StoreEncodedRanges(t *testing.T) {
+	t.Parallel()
+
+	var (
+		calls   atomic.Int64
+		body    = "0123456789"
+		encoded = encode(t, "gzip", body)
+	)
+
+	// The origin serves ranges of the gzip encoded representation to clients
+	// accepting it, with the same strong entity tag as the unencoded one.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+
+		content := []byte(body)
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+
+			content = encoded
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	transport := pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport)
+	transport.StoreEncoded = true
+
+	client := &http.Client{Transport: transport}
+
+	steps := []struct {
+		name           string
+		acceptEncoding string
+		wantEncoding   string
+		wantBody       []byte
+		wantCalls      int64
+	}{
+		{
+			name:           "Gzip client from origin",
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+			wantBody:       encoded[:4],
+			wantCalls:      1,
+		},
+		{
+			name:      "Identity client from origin",
+			wantBody:  []byte(body[:4]),
+			wantCalls: 2,
+		},
+		{
+			name:      "Identity client from segments",
+			wantBody:  []byte(body[:4]),
+			wantCalls: 2,
+		},
+		{
+			name:           "Gzip client from segments",
+			acceptEncoding: "gzip",
+			wantEncoding:   "gzip",
+			wantBody:       encoded[:4],
+			wantCalls:      2,
+		},
+	}
+
+	// The steps don't run in parallel, since each one depends on the cache
+	// state left by the previous ones.
+	for _, step := range steps {
+		step := step
+
+		t.Run(step.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL, http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Range", "bytes=0-3")
+
+			if step.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", step.acceptEncoding)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(got, step.wantBody) {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, got, http.StatusPartialContent, step.wantBody)
+			}
+
+			if enc := resp.Header.Get("Content-Encoding"); enc != step.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", enc, step.wantEncoding)
+			}
+
+			if n := calls.Load(); n != step.wantCalls {
+				t.Errorf("origin calls = %d, want %d", n, step.wantCalls)
+			}
+		})
+	}
+}
@@ -10,7 +10,7 @@ type Entry interface {
 	// Load loads the HTTP response from the cache entry.
 	Load(key string) (*http.Response, error)
 
-	// Access updates the last access time of the entry.
+	// Access updates the last access time and hit count of the entry.
 	Access()
 
 	// SetTTL sets the time-to-live of the entry.
@@ -22,3 +22,40 @@ type Entry interface {
 	// IsExpired checks if the entry is expired.
 	IsExpired() bool
 }
+
+// EntryInfo describes a cache entry without its response.
+type EntryInfo struct {
+	// StoredAt is the time the entry was stored at.
+	StoredAt time.Time
+
+	// Expiration is the time the entry expires at. The zero value means the
+	// entry does not expire.
+	Expiration time.Time
+
+	// LastAccess is the time the entry was last served at. The zero value
+	// means the entry was never served.
+	LastAccess time.Time
+
+	// LastModified is the value of the response's Last-Modified header. The
+	// zero value means the header is missing or invalid.
+	LastModified time.Time
+
+	// ETag is the value of the response's ETag header.
+	ETag string
+
+	// Key is the key the entry is stored under.
+	Key string
+
+	// URL is the URL of the request associated with the entry's response,
+	// normalized by NormalizeURL.
+	URL string
+
+	// StatusCode is the status code of the response.
+	StatusCode int
+
+	// Size is the size of the entry, in bytes.
+	Size uint64
+
+	// Hits is the number of times the entry was served.
+	Hits uint64
+}
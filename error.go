@@ -32,6 +32,10 @@ type Error struct {
 
 	// errType is the type of error.
 	errType ErrorType
+
+	// kindOf is the more general error this error is a kind of, if any, and
+	// matches with errors.Is.
+	kindOf *Error
 }
 
 // NewCacheError creates a new Error with the specified error type and underlying error.
@@ -42,6 +46,17 @@ func NewCacheError(errType ErrorType, err error) *Error {
 	}
 }
 
+// newKindError creates a new Error with the specified underlying error, being a
+// more specific kind of the given error: it has the same type, and matches it
+// with errors.Is.
+func newKindError(kindOf *Error, err error) *Error {
+	return &Error{
+		err:     err,
+		errType: kindOf.errType,
+		kindOf:  kindOf,
+	}
+}
+
 // Error returns a string representation of the CacheError.
 func (ce *Error) Error() string {
 	return fmt.Sprintf("cache error (%s): %v", ce.errType.String(), ce.err)
@@ -56,3 +71,9 @@ func (ce *Error) Type() ErrorType {
 func (ce *Error) Unwrap() error {
 	return ce.err
 }
+
+// Is reports whether the CacheError is a more specific kind of the given
+// error, such as ErrCacheExpired being a kind of ErrCacheMiss.
+func (ce *Error) Is(target error) bool {
+	return ce.kindOf != nil && target == error(ce.kindOf)
+}
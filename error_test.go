@@ -1,6 +1,7 @@
 package pagecache_test
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -94,3 +95,55 @@ func TestCacheError(t *testing.T) {
 		})
 	}
 }
+
+func TestCacheError_Is(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		err      error
+		wantMiss bool
+	}{
+		{
+			name:     "ErrCacheMiss",
+			err:      pagecache.ErrCacheMiss,
+			wantMiss: true,
+		},
+		{
+			name:     "ErrCacheExpired",
+			err:      pagecache.ErrCacheExpired,
+			wantMiss: true,
+		},
+		{
+			name:     "ErrVaryMiss",
+			err:      pagecache.ErrVaryMiss,
+			wantMiss: true,
+		},
+		{
+			name:     "Wrapped ErrKeyCollision",
+			err:      fmt.Errorf("get: %w", pagecache.ErrKeyCollision),
+			wantMiss: true,
+		},
+		{
+			name: "ErrCacheStoreFailed",
+			err:  pagecache.ErrCacheStoreFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := errors.Is(tt.err, pagecache.ErrCacheMiss); got != tt.wantMiss {
+				t.Errorf("errors.Is(%v, ErrCacheMiss) = %v, want %v", tt.err, got, tt.wantMiss)
+			}
+
+			// The general error doesn't match its specific kinds.
+			if errors.Is(pagecache.ErrCacheMiss, tt.err) && tt.err != pagecache.ErrCacheMiss {
+				t.Errorf("errors.Is(ErrCacheMiss, %v) = true, want false", tt.err)
+			}
+		})
+	}
+}
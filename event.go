@@ -0,0 +1,308 @@
+package pagecache
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEventShards is the number of goroutines a Dispatcher delivers events
+// from. Events for the same key are always delivered by the same goroutine.
+const DefaultEventShards int = 8
+
+// DefaultEventQueueSize is the maximum number of events each goroutine of a
+// Dispatcher queues for delivery before dropping new ones.
+const DefaultEventQueueSize int = 4096
+
+const (
+	// EventHit is emitted when a fresh entry is served.
+	EventHit EventType = iota
+
+	// EventMiss is emitted when no fresh entry is found.
+	EventMiss
+
+	// EventStore is emitted when an entry is stored.
+	EventStore
+
+	// EventEvict is emitted when an entry is removed for any reason other
+	// than expiring.
+	EventEvict
+
+	// EventExpire is emitted when an expired entry is removed.
+	EventExpire
+
+	// EventError is emitted when an operation fails.
+	EventError
+)
+
+// EventType represents the kind of cache activity an Event describes.
+type EventType int
+
+// String returns a string representation of the EventType.
+func (et EventType) String() string {
+	switch et {
+	case EventHit:
+		return "hit"
+	case EventMiss:
+		return "miss"
+	case EventStore:
+		return "store"
+	case EventEvict:
+		return "evict"
+	case EventExpire:
+		return "expire"
+	case EventError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// EvictCapacity means the entry was evicted to make room for others.
+	EvictCapacity EvictReason = iota
+
+	// EvictReplace means the entry was replaced by a new one under the same
+	// key.
+	EvictReplace
+
+	// EvictDelete means the entry was deleted explicitly.
+	EvictDelete
+
+	// EvictPurge means the entry was removed by purging the whole cache.
+	EvictPurge
+
+	// EvictTag means the entry was removed by purging one of its tags.
+	EvictTag
+
+	// EvictURL means the entry was removed by purging its URL, or URLs
+	// matching a pattern.
+	EvictURL
+)
+
+// EvictReason represents why an entry was evicted.
+type EvictReason int
+
+// String returns a string representation of the EvictReason.
+func (er EvictReason) String() string {
+	switch er {
+	case EvictCapacity:
+		return "capacity"
+	case EvictReplace:
+		return "replace"
+	case EvictDelete:
+		return "delete"
+	case EvictPurge:
+		return "purge"
+	case EvictTag:
+		return "tag"
+	case EvictURL:
+		return "url"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a single cache activity.
+type Event struct {
+	// Time is the time the event happened at.
+	Time time.Time
+
+	// Err is the error of an EventError.
+	Err error
+
+	// Key is the key of the entry the event refers to.
+	Key string
+
+	// URL is the normalized URL of the entry the event refers to, if known.
+	URL string
+
+	// Type is the kind of event.
+	Type EventType
+
+	// Reason is the reason of an EventEvict.
+	Reason EvictReason
+
+	// Size is the size of the entry the event refers to, in bytes, if known.
+	Size uint64
+
+	// Duration is how long the operation that caused the event took, if
+	// applicable.
+	Duration time.Duration
+}
+
+// Observer holds the functions called on cache activity. Any of them may be
+// nil.
+type Observer struct {
+	OnHit    func(event Event)
+	OnMiss   func(event Event)
+	OnStore  func(event Event)
+	OnEvict  func(event Event)
+	OnExpire func(event Event)
+	OnError  func(event Event)
+}
+
+// handler returns the function handling the given event type, or nil.
+func (o *Observer) handler(et EventType) func(event Event) {
+	switch et {
+	case EventHit:
+		return o.OnHit
+	case EventMiss:
+		return o.OnMiss
+	case EventStore:
+		return o.OnStore
+	case EventEvict:
+		return o.OnEvict
+	case EventExpire:
+		return o.OnExpire
+	case EventError:
+		return o.OnError
+	default:
+		return nil
+	}
+}
+
+// Dispatcher delivers events to an Observer asynchronously, so that slow
+// observers never block the cache emitting them. Events for the same key are
+// delivered in the order they were emitted.
+//
+// Up to DefaultEventQueueSize events are queued per goroutine until delivered.
+// Events emitted while the queue is full are dropped and counted, see Dropped,
+// so an observer that cannot keep up with the cache neither slows it down nor
+// grows memory usage without bound.
+type Dispatcher struct {
+	observer *Observer
+	shards   []*eventShard
+	wg       sync.WaitGroup
+	dropped  atomic.Uint64
+}
+
+// eventShard is a queue of events delivered by a single goroutine.
+type eventShard struct {
+	cond   *sync.Cond
+	queue  []Event
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewDispatcher creates a new Dispatcher delivering events to the given
+// observer. Close must be called to release its goroutines. A nil observer
+// returns a nil Dispatcher, which discards every event.
+func NewDispatcher(observer *Observer) *Dispatcher {
+	if observer == nil {
+		return nil
+	}
+
+	d := &Dispatcher{
+		observer: observer,
+		shards:   make([]*eventShard, DefaultEventShards),
+	}
+
+	for i := range d.shards {
+		shard := &eventShard{}
+		shard.cond = sync.NewCond(&shard.mu)
+
+		d.shards[i] = shard
+		d.wg.Add(1)
+
+		go d.run(shard)
+	}
+
+	return d
+}
+
+// Emit queues the event for delivery and returns immediately. Events emitted
+// on a nil Dispatcher, or after Close, are discarded, and those emitted while
+// the queue is full are dropped.
+func (d *Dispatcher) Emit(event Event) {
+	if d == nil || d.observer.handler(event.Type) == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	shard := d.shards[shardIndex(event.Key, len(d.shards))]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if shard.closed {
+		return
+	}
+
+	if len(shard.queue) >= DefaultEventQueueSize {
+		d.dropped.Add(1)
+
+		return
+	}
+
+	shard.queue = append(shard.queue, event)
+	shard.cond.Signal()
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (d *Dispatcher) Dropped() uint64 {
+	if d == nil {
+		return 0
+	}
+
+	return d.dropped.Load()
+}
+
+// Close delivers the events already queued, then stops the dispatcher.
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+
+	for _, shard := range d.shards {
+		shard.mu.Lock()
+		shard.closed = true
+		shard.cond.Signal()
+		shard.mu.Unlock()
+	}
+
+	d.wg.Wait()
+}
+
+// run delivers the events queued in the given shard until it is closed and
+// drained.
+func (d *Dispatcher) run(shard *eventShard) {
+	defer d.wg.Done()
+
+	for {
+		shard.mu.Lock()
+
+		for len(shard.queue) == 0 && !shard.closed {
+			shard.cond.Wait()
+		}
+
+		if len(shard.queue) == 0 && shard.closed {
+			shard.mu.Unlock()
+
+			return
+		}
+
+		events := shard.queue
+		shard.queue = nil
+
+		shard.mu.Unlock()
+
+		for _, event := range events {
+			if handler := d.observer.handler(event.Type); handler != nil {
+				handler(event)
+			}
+		}
+	}
+}
+
+// shardIndex returns the shard the events for the given key are delivered by.
+func shardIndex(key string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key)) //nolint:errcheck // hash.Hash.Write never returns an error
+
+	return int(h.Sum32() % uint32(shards))
+}
@@ -0,0 +1,194 @@
+package pagecache_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+func TestDispatcher_Emit(t *testing.T) {
+	t.Parallel()
+
+	const perKey = 100
+
+	var (
+		mu      sync.Mutex
+		got     = make(map[string][]int)
+		release = make(chan struct{})
+	)
+
+	dispatcher := pagecache.NewDispatcher(&pagecache.Observer{
+		OnStore: func(event pagecache.Event) {
+			<-release
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			got[event.Key] = append(got[event.Key], int(event.Size))
+		},
+	})
+
+	keys := []string{"a", "b", "c", "d"}
+
+	// The observer is blocked, so Emit returning at all shows that it does
+	// not wait for delivery.
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < perKey; i++ {
+			for _, key := range keys {
+				dispatcher.Emit(pagecache.Event{Key: key, Type: pagecache.EventStore, Size: uint64(i)})
+			}
+
+			// Events without a handler are discarded.
+			dispatcher.Emit(pagecache.Event{Key: "a", Type: pagecache.EventHit})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Emit() blocked on a slow observer")
+	}
+
+	close(release)
+	dispatcher.Close()
+
+	for _, key := range keys {
+		if len(got[key]) != perKey {
+			t.Fatalf("key %q got %d events, want %d", key, len(got[key]), perKey)
+		}
+
+		for i, size := range got[key] {
+			if size != i {
+				t.Fatalf("key %q event %d out of order: got %d", key, i, size)
+			}
+		}
+	}
+
+	// Emitting after Close, or on a nil Dispatcher, is a no-op.
+	dispatcher.Emit(pagecache.Event{Key: "a", Type: pagecache.EventStore})
+
+	var nilDispatcher *pagecache.Dispatcher
+
+	nilDispatcher.Emit(pagecache.Event{Key: "a", Type: pagecache.EventStore})
+	nilDispatcher.Close()
+}
+
+func TestDispatcher_Dropped(t *testing.T) {
+	t.Parallel()
+
+	var (
+		entered = make(chan struct{})
+		release = make(chan struct{})
+		once    sync.Once
+	)
+
+	dispatcher := pagecache.NewDispatcher(&pagecache.Observer{
+		OnStore: func(_ pagecache.Event) {
+			once.Do(func() { close(entered) })
+
+			<-release
+		},
+	})
+
+	// Block the observer on the first event, so the following ones are
+	// queued.
+	dispatcher.Emit(pagecache.Event{Key: "a", Type: pagecache.EventStore})
+	<-entered
+
+	const extra = 10
+
+	for i := 0; i < pagecache.DefaultEventQueueSize+extra; i++ {
+		dispatcher.Emit(pagecache.Event{Key: "a", Type: pagecache.EventStore})
+	}
+
+	if got := dispatcher.Dropped(); got != extra {
+		t.Errorf("Dropped() = %d, want %d", got, extra)
+	}
+
+	close(release)
+	dispatcher.Close()
+}
+
+func TestNewDispatcher_NilObserver(t *testing.T) {
+	t.Parallel()
+
+	dispatcher := pagecache.NewDispatcher(nil)
+
+	// A nil observer must not panic when events are emitted.
+	dispatcher.Emit(pagecache.Event{Key: "a", Type: pagecache.EventStore})
+	dispatcher.Close()
+
+	if got := dispatcher.Dropped(); got != 0 {
+		t.Errorf("Dropped() = %d, want 0", got)
+	}
+}
+
+func TestTransport_Observer(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "response")
+	}))
+	defer ts.Close()
+
+	var (
+		mu     sync.Mutex
+		events []pagecache.Event
+	)
+
+	record := func(event pagecache.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		events = append(events, event)
+	}
+
+	transport := pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport)
+	transport.Observer = &pagecache.Observer{
+		OnHit:   record,
+		OnMiss:  record,
+		OnStore: record,
+	}
+
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL + "/page")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Responses are stored once their body has been read.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	transport.Close()
+
+	want := []pagecache.EventType{pagecache.EventMiss, pagecache.EventStore, pagecache.EventHit}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+
+	for i, event := range events {
+		if event.Type != want[i] {
+			t.Errorf("event %d type = %v, want %v", i, event.Type, want[i])
+		}
+
+		if event.URL != ts.URL+"/page" || event.Key == "" || event.Time.IsZero() {
+			t.Errorf("event %d = %+v, want key, URL and time set", i, event)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package pagecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+
+	"git.sr.ht/~jamesponddotco/xstd-go/xhash/xfnv"
+)
+
+// HashFunc hashes the canonical form of a cache key into the key used to store
+// and retrieve entries.
+type HashFunc func(canonical string) string
+
+// HashFNV64 hashes the given string with the FNV-1a 64-bit algorithm, the same
+// variant used by HashFNV128. It is the fastest of the provided hash functions
+// and the one used by Key, but collisions become likely once billions of keys
+// are in use.
+func HashFNV64(canonical string) string {
+	return xfnv.String(canonical)
+}
+
+// HashFNV128 hashes the given string with the FNV-1a 128-bit algorithm, the
+// same variant used by HashFNV64, making collisions unlikely even with a very
+// large number of keys.
+func HashFNV128(canonical string) string {
+	h := fnv.New128a()
+	h.Write([]byte(canonical)) //nolint:errcheck // hash.Hash.Write never returns an error
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashSHA256 hashes the given string with the SHA-256 algorithm. It is the
+// slowest of the provided hash functions, and produces the longest keys, but
+// collisions are not a practical concern.
+func HashSHA256(canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+
+	return hex.EncodeToString(sum[:])
+}
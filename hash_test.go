@@ -0,0 +1,82 @@
+package pagecache_test
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+	"strconv"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+func TestHashFunc(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		hash    pagecache.HashFunc
+		wantLen int
+	}{
+		{
+			name:    "FNV 64-bit",
+			hash:    pagecache.HashFNV64,
+			wantLen: 16,
+		},
+		{
+			name:    "FNV 128-bit",
+			hash:    pagecache.HashFNV128,
+			wantLen: 32,
+		},
+		{
+			name:    "SHA-256",
+			hash:    pagecache.HashSHA256,
+			wantLen: 64,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				a = tt.hash("name:httpx:method:GET:url:https://example.com/a")
+				b = tt.hash("name:httpx:method:GET:url:https://example.com/b")
+			)
+
+			if len(a) != tt.wantLen {
+				t.Errorf("hash length = %d, want %d", len(a), tt.wantLen)
+			}
+
+			if a == b {
+				t.Errorf("different inputs hashed to the same value %q", a)
+			}
+
+			if again := tt.hash("name:httpx:method:GET:url:https://example.com/a"); again != a {
+				t.Errorf("hash is not deterministic: %q != %q", again, a)
+			}
+		})
+	}
+}
+
+func TestHashFNV_Variant(t *testing.T) {
+	t.Parallel()
+
+	const canonical = "name:httpx:method:GET:url:https://example.com/a"
+
+	// Both sizes must use FNV-1a, as implemented by hash/fnv.
+	h64 := fnv.New64a()
+	h64.Write([]byte(canonical)) //nolint:errcheck // hash.Hash.Write never returns an error
+
+	if got, want := pagecache.HashFNV64(canonical), strconv.FormatUint(h64.Sum64(), 16); got != want {
+		t.Errorf("HashFNV64() = %q, want FNV-1a %q", got, want)
+	}
+
+	h128 := fnv.New128a()
+	h128.Write([]byte(canonical)) //nolint:errcheck // see above
+
+	if got, want := pagecache.HashFNV128(canonical), hex.EncodeToString(h128.Sum(nil)); got != want {
+		t.Errorf("HashFNV128() = %q, want FNV-1a %q", got, want)
+	}
+}
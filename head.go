@@ -0,0 +1,145 @@
+package pagecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// asGet returns a copy of the given HEAD request using the GET method, whose
+// stored response can answer the HEAD request.
+func asGet(req *http.Request) *http.Request {
+	get := req.Clone(req.Context())
+	get.Method = http.MethodGet
+
+	return get
+}
+
+// lookup returns the stored response answering the given request, and the key
+// it is stored under. HEAD requests are answered from the response stored for
+// the matching GET request, without its body, if there is one, following RFC
+// 9110, Section 9.3.2, and from a stored HEAD response otherwise.
+//
+// Stored responses selected by their Vary header for other requests are not
+// returned, and ErrVaryMiss is returned instead.
+func (t *Transport) lookup(ctx context.Context, req *http.Request, key string) (*http.Response, string, error) {
+	if req.Method == http.MethodHead {
+		get := asGet(req)
+		getKey := t.Key(get)
+		getCtx := WithRequest(WithCanonicalKey(ctx, t.CanonicalKey(get)), get)
+
+		if resp, err := t.Cache.Get(getCtx, getKey); err == nil {
+			resp.Body.Close()
+			resp.Body = http.NoBody
+
+			if t.matchVariant(req, resp) {
+				return resp, getKey, nil
+			}
+		}
+	}
+
+	resp, err := t.Cache.Get(ctx, key)
+	if err != nil {
+		return nil, key, err
+	}
+
+	if !t.matchVariant(req, resp) {
+		resp.Body.Close()
+
+		return nil, key, fmt.Errorf("%w", ErrVaryMiss)
+	}
+
+	return resp, key, nil
+}
+
+// updateFromHead updates the response stored for the GET request matching the
+// given HEAD request with the given response to it, following RFC 9111,
+// Section 4.3.5. If the validators or length of the HEAD response show that
+// the representation changed, the stored response is removed instead.
+//
+// Stale stored responses are never served, and caches don't return them, so
+// one that can't be updated is removed too, whatever the HEAD response says.
+//
+// Failing to update the stored response must not fail the request, so errors
+// are ignored.
+func (t *Transport) updateFromHead(req *http.Request, resp *http.Response, policy *Policy) {
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	var (
+		get    = asGet(req)
+		getKey = t.Key(get)
+		ctx    = WithRequest(WithCanonicalKey(req.Context(), t.CanonicalKey(get)), get)
+	)
+
+	stored, err := t.Cache.Get(ctx, getKey)
+	if errors.Is(err, ErrCacheExpired) {
+		_ = t.Cache.Delete(ctx, getKey) //nolint:errcheck // see above
+
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	// The stored response can only be updated from a HEAD response to a
+	// request it may be served for.
+	if !t.matchVariant(req, stored) {
+		stored.Body.Close()
+
+		return
+	}
+
+	if !sameRepresentation(stored.Header, resp.Header) {
+		stored.Body.Close()
+
+		_ = t.Cache.Delete(ctx, getKey) //nolint:errcheck // see above
+
+		return
+	}
+
+	updated := *stored
+	updated.Request = get
+	updated.Header = stored.Header.Clone()
+
+	for name, values := range resp.Header {
+		// The length of the stored body is authoritative, and the members of
+		// Cache-Status describe the HEAD response only.
+		if name == "Content-Length" || name == CacheStatusHeader {
+			continue
+		}
+
+		updated.Header[name] = append([]string(nil), values...)
+	}
+
+	if !policy.IsCacheable(&updated) {
+		stored.Body.Close()
+
+		_ = t.Cache.Delete(ctx, getKey) //nolint:errcheck // see above
+
+		return
+	}
+
+	if err := t.Cache.Set(ctx, getKey, t.withVariant(get, &updated), policy.TTL(&updated)); err == nil {
+		_ = RecordRevalidation(ctx, t.Cache) //nolint:errcheck // see above
+	}
+}
+
+// sameRepresentation reports whether a HEAD response with the given header
+// describes the same representation as a stored response with the given
+// header: the entity tags, Last-Modified dates and Content-Length values they
+// carry, if any, must be equal.
+func sameRepresentation(stored, head http.Header) bool {
+	for _, name := range []string{"ETag", "Last-Modified"} {
+		if value := head.Get(name); value != "" && value != stored.Get(name) {
+			return false
+		}
+	}
+
+	length, storedLength := head.Get("Content-Length"), stored.Get("Content-Length")
+
+	return length == "" || storedLength == "" || length == storedLength
+}
@@ -0,0 +1,305 @@
+package pagecache_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+func TestTransport_RoundTrip_Head(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		// headETag is the entity tag the origin sends when revalidating with
+		// HEAD.
+		headETag string
+		// wantGetCalls is the number of GET requests reaching the origin,
+		// including the first one.
+		wantGetCalls int
+		// wantHeader is the value of X-Version of the GET response served
+		// after the HEAD revalidation.
+		wantHeader string
+	}{
+		{
+			name:         "Unchanged validator freshens stored GET",
+			headETag:     `"v1"`,
+			wantGetCalls: 1,
+			wantHeader:   "head",
+		},
+		{
+			name:         "Changed validator invalidates stored GET",
+			headETag:     `"v2"`,
+			wantGetCalls: 2,
+			wantHeader:   "get",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu    sync.Mutex
+				calls = make(map[string]int)
+			)
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				calls[r.Method]++
+				mu.Unlock()
+
+				w.Header().Set("Cache-Control", "max-age=3600")
+				w.Header().Set("Content-Type", "text/plain")
+
+				if r.Method == http.MethodHead {
+					w.Header().Set("ETag", tt.headETag)
+					w.Header().Set("X-Version", "head")
+					w.Header().Set("Content-Length", "4")
+
+					return
+				}
+
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("X-Version", "get")
+
+				io.WriteString(w, "page") //nolint:errcheck // test server
+			}))
+			defer ts.Close()
+
+			client := &http.Client{
+				Transport: pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport),
+			}
+
+			do := func(method string, header http.Header) (*http.Response, string) {
+				t.Helper()
+
+				req, err := http.NewRequest(method, ts.URL, http.NoBody)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for name, values := range header {
+					req.Header[name] = values
+				}
+
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("Do() unexpected error: %v", err)
+				}
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return resp, string(body)
+			}
+
+			do(http.MethodGet, nil)
+
+			resp, body := do(http.MethodHead, nil)
+			if resp.StatusCode != http.StatusOK || body != "" {
+				t.Fatalf("HEAD = %d %q, want 200 with an empty body", resp.StatusCode, body)
+			}
+
+			if got := resp.Header.Get("Content-Length"); got != "4" {
+				t.Errorf("HEAD Content-Length = %q, want %q", got, "4")
+			}
+
+			mu.Lock()
+			headCalls := calls[http.MethodHead]
+			mu.Unlock()
+
+			if headCalls != 0 {
+				t.Fatalf("origin called %d times for HEAD, want 0", headCalls)
+			}
+
+			// Revalidate with HEAD, bypassing the cache.
+			do(http.MethodHead, http.Header{"Cache-Control": {"no-cache"}})
+
+			resp, body = do(http.MethodGet, nil)
+			if body != "page" {
+				t.Errorf("GET body = %q, want %q", body, "page")
+			}
+
+			if got := resp.Header.Get("X-Version"); got != tt.wantHeader {
+				t.Errorf("GET X-Version = %q, want %q", got, tt.wantHeader)
+			}
+
+			if got := resp.Header.Get("Content-Length"); got != "4" {
+				t.Errorf("GET Content-Length = %q, want %q", got, "4")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if got := calls[http.MethodGet]; got != tt.wantGetCalls {
+				t.Errorf("origin called %d times for GET, want %d", got, tt.wantGetCalls)
+			}
+		})
+	}
+}
+
+// expiringCache reports every stored entry as expired once expired is set,
+// like a cache keeping expired entries until they are deleted.
+type expiringCache struct {
+	pagecache.Cache
+	expired atomic.Bool
+}
+
+func (ec *expiringCache) Get(ctx context.Context, key string) (*http.Response, error) {
+	resp, err := ec.Cache.Get(ctx, key)
+	if err != nil || !ec.expired.Load() {
+		return resp, err
+	}
+
+	resp.Body.Close()
+
+	return nil, pagecache.ErrCacheExpired
+}
+
+func TestTransport_RoundTrip_HeadStale(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("ETag", `"v1"`)
+
+		io.WriteString(w, "page") //nolint:errcheck // test server
+	}))
+	defer ts.Close()
+
+	var (
+		underlying = memorycachex.NewCache(nil, 0)
+		cache      = &expiringCache{Cache: underlying}
+		transport  = pagecache.NewTransport(cache, ts.Client().Transport)
+		client     = &http.Client{Transport: transport}
+	)
+
+	do := func(method string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, ts.URL, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do() unexpected error: %v", err)
+		}
+
+		io.Copy(io.Discard, resp.Body) //nolint:errcheck // test
+		resp.Body.Close()
+	}
+
+	do(http.MethodGet)
+
+	get, err := http.NewRequest(http.MethodGet, ts.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := underlying.Inspect(context.Background(), transport.Key(get)); err != nil {
+		t.Fatalf("Inspect() after GET unexpected error: %v", err)
+	}
+
+	cache.expired.Store(true)
+
+	do(http.MethodHead)
+
+	if _, err := underlying.Inspect(context.Background(), transport.Key(get)); !errors.Is(err, pagecache.ErrCacheMiss) {
+		t.Errorf("Inspect() of the stale GET after HEAD = %v, want %v", err, pagecache.ErrCacheMiss)
+	}
+}
+
+func TestTransport_RoundTrip_HeadCacheStatusKey(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=3600")
+
+		io.WriteString(w, "page") //nolint:errcheck // test server
+	}))
+	defer ts.Close()
+
+	var (
+		transport = pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport)
+		client    = &http.Client{Transport: transport}
+	)
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck // test
+	resp.Body.Close()
+
+	resp, err = client.Head(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	// The HEAD request is answered from the stored GET response, whose key
+	// Cache-Status reports.
+	get := resp.Request.Clone(context.Background())
+	get.Method = http.MethodGet
+
+	want := transport.Key(get)
+
+	if got := resp.Header.Get(pagecache.CacheStatusHeader); !strings.Contains(got, `key="`+want+`"`) {
+		t.Errorf("Cache-Status = %q, want key %q", got, want)
+	}
+}
+
+func TestTransport_RoundTrip_HeadContentLength(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+
+		// Only HEAD requests are made, so the body is never sent.
+		w.Header().Set("Content-Length", "1234")
+		w.Header().Set("Cache-Control", "max-age=3600")
+	}))
+	defer ts.Close()
+
+	client := &http.Client{
+		Transport: pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport),
+	}
+
+	for _, name := range []string{"store", "hit"} {
+		resp, err := client.Head(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.ContentLength != 1234 || resp.Header.Get("Content-Length") != "1234" {
+			t.Errorf("%s: Content-Length = %d (header %q), want 1234", name, resp.ContentLength, resp.Header.Get("Content-Length"))
+		}
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("origin calls = %d, want 1", got)
+	}
+}
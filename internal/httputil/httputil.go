@@ -10,6 +10,10 @@ import (
 // IsBodySizeWithinLimit checks if the Content-Length of the response is within
 // the specified limit. If the limit is zero or negative, it always returns
 // true.
+//
+// Only the declared length is checked, so responses without a valid
+// Content-Length are assumed to be within the limit; callers must enforce it
+// on the bytes actually read as well.
 func IsBodySizeWithinLimit(header http.Header, limit int64) bool {
 	if limit <= 0 {
 		return true
@@ -25,6 +29,32 @@ func IsBodySizeWithinLimit(header http.Header, limit int64) bool {
 	return contentLength <= limit
 }
 
+// HeaderSize returns the total size of the given header fields, in bytes, as
+// they are sent on the wire: each field line counts its name, value, the
+// separating colon and space, and the terminating CRLF.
+func HeaderSize(header http.Header) int64 {
+	var size int64
+
+	for name, values := range header {
+		for _, value := range values {
+			size += int64(len(name) + len(value) + len(": \r\n"))
+		}
+	}
+
+	return size
+}
+
+// IsHeaderSizeWithinLimit checks if the total size of the given header fields
+// is within the specified limit. If the limit is zero or negative, it always
+// returns true.
+func IsHeaderSizeWithinLimit(header http.Header, limit int64) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	return HeaderSize(header) <= limit
+}
+
 // MaxAge returns the max-age value in seconds if found in the Cache-Control
 // header. It returns -1 if the header is not present or if the value is not a
 // valid number.
@@ -54,3 +84,20 @@ func MaxAge(header http.Header) int {
 
 	return -1
 }
+
+// HasDirective reports whether the Cache-Control header contains the given
+// directive, with or without an argument. Directive names are compared
+// case-insensitively.
+func HasDirective(header http.Header, directive string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+
+			if strings.EqualFold(strings.TrimSpace(name), directive) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
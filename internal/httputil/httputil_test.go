@@ -62,6 +62,61 @@ func TestIsBodySizeWithinLimit(t *testing.T) {
 	}
 }
 
+func TestIsHeaderSizeWithinLimit(t *testing.T) {
+	t.Parallel()
+
+	// "Etag: abc\r\n" and "Vary: a\r\n" and "Vary: b\r\n" add up to 29 bytes.
+	header := http.Header{
+		"Etag": []string{"abc"},
+		"Vary": []string{"a", "b"},
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		limit  int64
+		want   bool
+	}{
+		{
+			name:   "Zero limit",
+			header: header,
+			limit:  0,
+			want:   true,
+		},
+		{
+			name:   "Nil header",
+			header: nil,
+			limit:  1,
+			want:   true,
+		},
+		{
+			name:   "Exactly at limit",
+			header: header,
+			limit:  29,
+			want:   true,
+		},
+		{
+			name:   "Exceeding limit",
+			header: header,
+			limit:  28,
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := httputil.IsHeaderSizeWithinLimit(tt.header, tt.limit)
+			if got != tt.want {
+				t.Errorf("IsHeaderSizeWithinLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestMaxAge(t *testing.T) {
 	t.Parallel()
 
@@ -110,3 +165,57 @@ func TestMaxAge(t *testing.T) {
 		})
 	}
 }
+
+func TestHasDirective(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		header    http.Header
+		directive string
+		want      bool
+	}{
+		{
+			name:      "Nil header",
+			header:    nil,
+			directive: "no-cache",
+			want:      false,
+		},
+		{
+			name:      "Directive present",
+			header:    http.Header{"Cache-Control": []string{"max-age=0, No-Cache"}},
+			directive: "no-cache",
+			want:      true,
+		},
+		{
+			name:      "Directive with argument",
+			header:    http.Header{"Cache-Control": []string{`no-cache="Set-Cookie"`}},
+			directive: "no-cache",
+			want:      true,
+		},
+		{
+			name:      "Directive in second header line",
+			header:    http.Header{"Cache-Control": []string{"public", "no-store"}},
+			directive: "no-store",
+			want:      true,
+		},
+		{
+			name:      "Directive absent",
+			header:    http.Header{"Cache-Control": []string{"no-store"}},
+			directive: "no-cache",
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := httputil.HasDirective(tt.header, tt.directive); got != tt.want {
+				t.Errorf("HasDirective() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package pagecache
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// isUnsafeMethod checks if the given HTTP method is unsafe, meaning a request
+// using it may change the state of the target resource.
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
+
+// invalidate removes the cached responses made stale by the given unsafe
+// request, following RFC 9111, Section 4.4: if the response status is not an
+// error, the target URI and the URIs in the Location and Content-Location
+// headers are invalidated, the latter only when they share the origin of the
+// target URI.
+func (t *Transport) invalidate(req *http.Request, resp *http.Response) {
+	if !isUnsafeMethod(req.Method) || resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return
+	}
+
+	t.invalidateURL(req, req.URL)
+
+	for _, header := range []string{"Location", "Content-Location"} {
+		value := resp.Header.Get(header)
+		if value == "" {
+			continue
+		}
+
+		location, err := req.URL.Parse(value)
+		if err != nil || location.Scheme != req.URL.Scheme || location.Host != req.URL.Host {
+			continue
+		}
+
+		t.invalidateURL(req, location)
+	}
+}
+
+// invalidateURL removes every cached response for the given URL. If the cache
+// implements URLPurger, every variant is removed; otherwise, or if it returns
+// ErrUnsupported, only the GET and HEAD entries and the stored partial
+// responses matching the given request's headers and credentials are, the
+// latter only when unencoded or encoded with gzip or deflate.
+func (t *Transport) invalidateURL(req *http.Request, uri *url.URL) {
+	ctx := req.Context()
+
+	if purger, ok := t.Cache.(URLPurger); ok {
+		// Failing to invalidate must not fail the request, so errors other
+		// than ErrUnsupported are ignored.
+		if err := purger.PurgeURL(ctx, NormalizeURL(uri)); !errors.Is(err, ErrUnsupported) {
+			return
+		}
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		variant := req.Clone(ctx)
+		variant.Method = method
+		variant.URL = uri
+
+		_ = t.Cache.Delete(ctx, t.Key(variant)) //nolint:errcheck // see above
+
+		if method == http.MethodGet {
+			for _, coding := range []string{"", codingGzip, codingDeflate} {
+				key, _ := t.segmentsKey(variant, coding)
+
+				_ = t.Cache.Delete(ctx, key) //nolint:errcheck // see above
+			}
+		}
+	}
+}
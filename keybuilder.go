@@ -0,0 +1,157 @@
+package pagecache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"git.sr.ht/~jamesponddotco/xstd-go/xnet/xurl"
+)
+
+// KeyBuilder generates cache keys from requests in a configurable way. Its
+// zero value generates the same keys as Key.
+//
+// Query parameters are always sorted and the host is always lowercased, so
+// requests that only differ by parameter order or host case share a key.
+type KeyBuilder struct {
+	// IgnoredParams is a list of query parameters to drop from the URL before
+	// generating the key, such as "utm_source". A name ending in "*" matches
+	// every parameter starting with the rest of the name, such as "utm_*".
+	IgnoredParams map[string]struct{} //nolint:revive // see Policy.AllowedStatusCodes
+
+	// AllowedParams is a list of query parameters to keep in the URL before
+	// generating the key. If not empty, every other parameter is dropped.
+	// Names ending in "*" are handled as in IgnoredParams.
+	AllowedParams map[string]struct{} //nolint:revive // see Policy.AllowedStatusCodes
+
+	// Headers is a list of request headers whose values are folded into the
+	// key, so that requests differing by them are cached separately.
+	Headers []string
+
+	// Cookies is a list of request cookies whose values are folded into the
+	// key, so that requests differing by them are cached separately.
+	Cookies []string
+
+	// Hash hashes the canonical form of the key. If nil, HashFNV64 is used,
+	// like Key does.
+	Hash HashFunc
+
+	// IgnoreScheme controls whether requests that only differ by their URL
+	// scheme, such as http and https, share a key.
+	IgnoreScheme bool
+}
+
+// Key generates a cache key for the given *http.Request, cache name, and
+// optional extra information, according to the KeyBuilder's configuration.
+//
+// The generated key is the hash of the one returned by Canonical.
+func (kb *KeyBuilder) Key(name string, req *http.Request, extra ...string) string {
+	hash := kb.Hash
+	if hash == nil {
+		hash = HashFNV64
+	}
+
+	return hash(kb.Canonical(name, req, extra...))
+}
+
+// Canonical returns the canonical form of the cache key for the given
+// *http.Request, cache name, and optional extra information, before hashing.
+//
+// It is of the same form as the one returned by CanonicalKey, including the
+// credential identity, with the configured headers and cookies added to the
+// extra information as "header:NAME=VALUE" and "cookie:NAME=VALUE".
+func (kb *KeyBuilder) Canonical(name string, req *http.Request, extra ...string) string {
+	values := make([]string, 0, len(kb.Headers)+len(kb.Cookies)+len(extra))
+
+	for _, header := range kb.Headers {
+		values = append(values, "header:"+http.CanonicalHeaderKey(header)+"="+strings.Join(req.Header.Values(header), ","))
+	}
+
+	for _, cookie := range kb.Cookies {
+		var value string
+
+		if c, err := req.Cookie(cookie); err == nil {
+			value = c.Value
+		}
+
+		values = append(values, "cookie:"+cookie+"="+value)
+	}
+
+	values = append(values, extra...)
+	values = withCredentials(req, values)
+
+	return buildKey(name, req.Method, kb.url(req.URL), values)
+}
+
+// url returns the normalized form of the given URL according to the
+// KeyBuilder's configuration.
+func (kb *KeyBuilder) url(uri *url.URL) string {
+	if len(kb.IgnoredParams) > 0 || len(kb.AllowedParams) > 0 {
+		filtered := *uri
+		filtered.RawQuery = kb.filterQuery(uri.Query()).Encode()
+		uri = &filtered
+	}
+
+	normalized := NormalizeURL(uri)
+
+	if kb.IgnoreScheme {
+		if i := strings.Index(normalized, "://"); i != -1 {
+			normalized = normalized[i+1:]
+		}
+	}
+
+	return normalized
+}
+
+// filterQuery removes the ignored and non-allowed parameters from the given
+// query.
+func (kb *KeyBuilder) filterQuery(query url.Values) url.Values {
+	for param := range query {
+		if matchParam(kb.IgnoredParams, param) {
+			query.Del(param)
+
+			continue
+		}
+
+		if len(kb.AllowedParams) > 0 && !matchParam(kb.AllowedParams, param) {
+			query.Del(param)
+		}
+	}
+
+	return query
+}
+
+// matchParam checks if the given query parameter is part of the given list,
+// either by name or by a prefix ending in "*".
+func matchParam(params map[string]struct{}, param string) bool { //nolint:revive // see Policy.AllowedStatusCodes
+	if _, ok := params[param]; ok {
+		return true
+	}
+
+	for p := range params {
+		if strings.HasSuffix(p, "*") && strings.HasPrefix(param, p[:len(p)-1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NormalizeURL returns the normalized form of the given URL, without userinfo.
+// Credentials are accounted for separately, see Credentials.
+func NormalizeURL(uri *url.URL) string {
+	if uri.User != nil {
+		stripped := *uri
+		stripped.User = nil
+		uri = &stripped
+	}
+
+	raw := uri.String()
+
+	normalized, err := xurl.Normalize(raw)
+	if err != nil {
+		return raw
+	}
+
+	return normalized
+}
@@ -0,0 +1,197 @@
+package pagecache_test
+
+import (
+	"net/http"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+func TestKeyBuilder_Key(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		builder   *pagecache.KeyBuilder
+		giveA     func(t *testing.T) *http.Request
+		giveB     func(t *testing.T) *http.Request
+		wantEqual bool
+	}{
+		{
+			name:      "Reordered parameters",
+			builder:   &pagecache.KeyBuilder{},
+			giveA:     newTestRequest("https://example.com/?a=1&b=2", nil),
+			giveB:     newTestRequest("https://example.com/?b=2&a=1", nil),
+			wantEqual: true,
+		},
+		{
+			name:      "Host case",
+			builder:   &pagecache.KeyBuilder{},
+			giveA:     newTestRequest("https://EXAMPLE.com/page", nil),
+			giveB:     newTestRequest("https://example.com/page", nil),
+			wantEqual: true,
+		},
+		{
+			name:      "Tracking parameter without ignored parameters",
+			builder:   &pagecache.KeyBuilder{},
+			giveA:     newTestRequest("https://example.com/?utm_source=x", nil),
+			giveB:     newTestRequest("https://example.com/", nil),
+			wantEqual: false,
+		},
+		{
+			name: "Ignored parameters",
+			builder: &pagecache.KeyBuilder{
+				IgnoredParams: map[string]struct{}{"utm_*": {}, "fbclid": {}},
+			},
+			giveA:     newTestRequest("https://example.com/?id=1&utm_source=x&utm_medium=y&fbclid=z", nil),
+			giveB:     newTestRequest("https://example.com/?id=1", nil),
+			wantEqual: true,
+		},
+		{
+			name: "Allowed parameters",
+			builder: &pagecache.KeyBuilder{
+				AllowedParams: map[string]struct{}{"id": {}},
+			},
+			giveA:     newTestRequest("https://example.com/?id=1&session=abc", nil),
+			giveB:     newTestRequest("https://example.com/?id=1&session=def", nil),
+			wantEqual: true,
+		},
+		{
+			name: "Allowed parameters with different values",
+			builder: &pagecache.KeyBuilder{
+				AllowedParams: map[string]struct{}{"id": {}},
+			},
+			giveA:     newTestRequest("https://example.com/?id=1", nil),
+			giveB:     newTestRequest("https://example.com/?id=2", nil),
+			wantEqual: false,
+		},
+		{
+			name:      "Scheme",
+			builder:   &pagecache.KeyBuilder{},
+			giveA:     newTestRequest("http://example.com/", nil),
+			giveB:     newTestRequest("https://example.com/", nil),
+			wantEqual: false,
+		},
+		{
+			name:      "Ignored scheme",
+			builder:   &pagecache.KeyBuilder{IgnoreScheme: true},
+			giveA:     newTestRequest("http://example.com/", nil),
+			giveB:     newTestRequest("https://example.com/", nil),
+			wantEqual: true,
+		},
+		{
+			name:    "Headers",
+			builder: &pagecache.KeyBuilder{Headers: []string{"accept-language"}},
+			giveA: newTestRequest("https://example.com/", http.Header{
+				"Accept-Language": []string{"en"},
+			}),
+			giveB: newTestRequest("https://example.com/", http.Header{
+				"Accept-Language": []string{"pt"},
+			}),
+			wantEqual: false,
+		},
+		{
+			name:    "Unlisted headers",
+			builder: &pagecache.KeyBuilder{Headers: []string{"Accept-Language"}},
+			giveA: newTestRequest("https://example.com/", http.Header{
+				"Accept-Language": []string{"en"},
+				"User-Agent":      []string{"a"},
+			}),
+			giveB: newTestRequest("https://example.com/", http.Header{
+				"Accept-Language": []string{"en"},
+				"User-Agent":      []string{"b"},
+			}),
+			wantEqual: true,
+		},
+		{
+			name:    "Cookies",
+			builder: &pagecache.KeyBuilder{Cookies: []string{"currency"}},
+			giveA: newTestRequest("https://example.com/", http.Header{
+				"Cookie": []string{"currency=EUR; _ga=1"},
+			}),
+			giveB: newTestRequest("https://example.com/", http.Header{
+				"Cookie": []string{"currency=USD; _ga=1"},
+			}),
+			wantEqual: false,
+		},
+		{
+			name:    "Unlisted cookies",
+			builder: &pagecache.KeyBuilder{Cookies: []string{"currency"}},
+			giveA: newTestRequest("https://example.com/", http.Header{
+				"Cookie": []string{"currency=EUR; _ga=1"},
+			}),
+			giveB: newTestRequest("https://example.com/", http.Header{
+				"Cookie": []string{"currency=EUR; _ga=2"},
+			}),
+			wantEqual: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				keyA = tt.builder.Key("", tt.giveA(t))
+				keyB = tt.builder.Key("", tt.giveB(t))
+			)
+
+			if (keyA == keyB) != tt.wantEqual {
+				t.Errorf("Key() = %q and %q, want equal: %v", keyA, keyB, tt.wantEqual)
+			}
+		})
+	}
+}
+
+func TestKeyBuilder_Key_Default(t *testing.T) {
+	t.Parallel()
+
+	req := newTestRequest("https://example.com/?b=2&a=1", nil)(t)
+
+	var (
+		builder = &pagecache.KeyBuilder{}
+		got     = builder.Key("foo", req, "bar")
+		want    = pagecache.Key("foo", req, "bar")
+	)
+
+	if got != want {
+		t.Errorf("Key() = %q, want %q", got, want)
+	}
+}
+
+func TestKeyBuilder_Hash(t *testing.T) {
+	t.Parallel()
+
+	var (
+		req     = newTestRequest("https://example.com/", nil)(t)
+		builder = &pagecache.KeyBuilder{Hash: pagecache.HashSHA256}
+		want    = pagecache.HashSHA256(pagecache.CanonicalKey("", req))
+	)
+
+	if got := builder.Key("", req); got != want {
+		t.Errorf("Key() = %q, want %q", got, want)
+	}
+
+	if got := builder.Canonical("", req); got != pagecache.CanonicalKey("", req) {
+		t.Errorf("Canonical() = %q, want %q", got, pagecache.CanonicalKey("", req))
+	}
+}
+
+func newTestRequest(rawURL string, header http.Header) func(t *testing.T) *http.Request {
+	return func(t *testing.T) *http.Request {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, rawURL, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header != nil {
+			req.Header = header
+		}
+
+		return req
+	}
+}
@@ -0,0 +1,81 @@
+package pagecache
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URLMatcher matches the URLs of cache entries, normalized by NormalizeURL.
+type URLMatcher interface {
+	// MatchURL returns true if the given normalized URL matches.
+	MatchURL(url string) bool
+}
+
+// URLMatcherFunc is an adapter to allow the use of ordinary functions as
+// URLMatchers.
+type URLMatcherFunc func(url string) bool
+
+// MatchURL calls f(url).
+func (f URLMatcherFunc) MatchURL(url string) bool {
+	return f(url)
+}
+
+// MatchPrefix returns a URLMatcher matching every URL starting with the given
+// prefix, such as "https://example.com/blog/". Since normalized URLs have no
+// trailing slash, a prefix ending in a slash also matches the URL without it.
+func MatchPrefix(prefix string) URLMatcher {
+	return URLMatcherFunc(func(url string) bool {
+		return strings.HasPrefix(url, prefix) || url == strings.TrimSuffix(prefix, "/")
+	})
+}
+
+// MatchHost returns a URLMatcher matching every URL on the given host. If the
+// host includes a port, the port must match as well.
+func MatchHost(host string) URLMatcher {
+	host = strings.ToLower(host)
+
+	return URLMatcherFunc(func(rawURL string) bool {
+		uri, err := url.Parse(rawURL)
+		if err != nil {
+			return false
+		}
+
+		if strings.Contains(host, ":") {
+			return uri.Host == host
+		}
+
+		return uri.Hostname() == host
+	})
+}
+
+// MatchRule returns a URLMatcher matching the same URLs as the given rule,
+// either exactly or with its regular expression pattern. It returns an error
+// if the rule is invalid.
+func MatchRule(rule *Rule) (URLMatcher, error) {
+	re, err := rule.compile()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if re == nil {
+		exact := rule.URL
+
+		return URLMatcherFunc(func(url string) bool {
+			return url == exact
+		}), nil
+	}
+
+	return regexpMatcher{regex: re}, nil
+}
+
+// regexpMatcher is a URLMatcher backed by a regular expression.
+type regexpMatcher struct {
+	regex *regexp.Regexp
+}
+
+// MatchURL returns true if the regular expression matches the URL.
+func (rm regexpMatcher) MatchURL(url string) bool {
+	return rm.regex.MatchString(url)
+}
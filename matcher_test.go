@@ -0,0 +1,179 @@
+package pagecache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+func TestURLMatcher(t *testing.T) {
+	t.Parallel()
+
+	ruleMatcher, err := pagecache.MatchRule(&pagecache.Rule{Pattern: `^https://example\.com/\d+$`})
+	if err != nil {
+		t.Fatalf("MatchRule() unexpected error: %v", err)
+	}
+
+	exactMatcher, err := pagecache.MatchRule(&pagecache.Rule{URL: "https://example.com/about"})
+	if err != nil {
+		t.Fatalf("MatchRule() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		matcher pagecache.URLMatcher
+		url     string
+		want    bool
+	}{
+		{
+			name:    "Prefix match",
+			matcher: pagecache.MatchPrefix("https://example.com/blog/"),
+			url:     "https://example.com/blog/post",
+			want:    true,
+		},
+		{
+			name:    "Prefix without trailing slash",
+			matcher: pagecache.MatchPrefix("https://example.com/blog/"),
+			url:     "https://example.com/blog",
+			want:    true,
+		},
+		{
+			name:    "Prefix no match",
+			matcher: pagecache.MatchPrefix("https://example.com/blog/"),
+			url:     "https://example.com/blogroll",
+			want:    false,
+		},
+		{
+			name:    "Host match",
+			matcher: pagecache.MatchHost("Example.com"),
+			url:     "https://example.com:8443/page",
+			want:    true,
+		},
+		{
+			name:    "Host with port no match",
+			matcher: pagecache.MatchHost("example.com:443"),
+			url:     "https://example.com:8443/page",
+			want:    false,
+		},
+		{
+			name:    "Host no match",
+			matcher: pagecache.MatchHost("example.com"),
+			url:     "https://www.example.com/page",
+			want:    false,
+		},
+		{
+			name:    "Rule pattern match",
+			matcher: ruleMatcher,
+			url:     "https://example.com/42",
+			want:    true,
+		},
+		{
+			name:    "Rule pattern no match",
+			matcher: ruleMatcher,
+			url:     "https://example.com/abc",
+			want:    false,
+		},
+		{
+			name:    "Rule URL match",
+			matcher: exactMatcher,
+			url:     "https://example.com/about",
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.matcher.MatchURL(tt.url); got != tt.want {
+				t.Errorf("MatchURL(%q) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRule_Invalid(t *testing.T) {
+	t.Parallel()
+
+	if _, err := pagecache.MatchRule(&pagecache.Rule{Pattern: "[invalid"}); err == nil {
+		t.Error("MatchRule() expected error, got nil")
+	}
+}
+
+// listerCache hides every optional interface of the wrapped cache except
+// Lister.
+type listerCache struct {
+	pagecache.Cache
+	lister pagecache.Lister
+}
+
+func (lc *listerCache) Range(ctx context.Context, fn func(info pagecache.EntryInfo) bool) error {
+	return lc.lister.Range(ctx, fn)
+}
+
+func TestPurgeMatching(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		wrap func(cache *memorycachex.MemoryCache) pagecache.Cache
+	}{
+		{
+			name: "MatchPurger",
+			wrap: func(cache *memorycachex.MemoryCache) pagecache.Cache {
+				return cache
+			},
+		},
+		{
+			name: "Lister fallback",
+			wrap: func(cache *memorycachex.MemoryCache) pagecache.Cache {
+				return &listerCache{Cache: cache, lister: cache}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				ctx   = context.Background()
+				cache = tt.wrap(memorycachex.NewCache(nil, 0))
+				urls  = []string{
+					"https://example.com/blog/",
+					"https://example.com/blog/post-1",
+					"https://example.com/blog/post-2?page=2",
+					"https://example.com/about",
+				}
+			)
+
+			for _, u := range urls {
+				resp := newTestResponse(t)
+				resp.Request = newTestRequest(u, nil)(t)
+
+				if err := cache.Set(ctx, pagecache.Key("", resp.Request), resp, time.Minute); err != nil {
+					t.Fatalf("Set() unexpected error: %v", err)
+				}
+			}
+
+			if err := pagecache.PurgeMatching(ctx, cache, pagecache.MatchPrefix("https://example.com/blog/")); err != nil {
+				t.Fatalf("PurgeMatching() unexpected error: %v", err)
+			}
+
+			for i, u := range urls {
+				_, err := cache.Get(ctx, pagecache.Key("", newTestRequest(u, nil)(t)))
+
+				if found := err == nil; found != (i == len(urls)-1) {
+					t.Errorf("Get(%q) error = %v, want found: %v", u, err, i == len(urls)-1)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package pagecache
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MediaTypePolicy defines caching defaults for responses of a given media
+// type.
+type MediaTypePolicy struct {
+	// TTL is the default time-to-live of responses of the media type. Zero
+	// means the media type does not set a TTL, and the next applicable
+	// default is used instead.
+	TTL time.Duration
+
+	// MaxBodySize is the maximum size of the response body allowed to be
+	// cached, in bytes, overriding Policy.MaxBodySize. Zero means
+	// Policy.MaxBodySize is used, and a negative value indicates no limit.
+	MaxBodySize int64
+}
+
+// mediaTypePolicy returns the MediaTypePolicy matching the Content-Type of the
+// given header. An exact media type, such as "text/html", takes precedence
+// over a wildcard media range, such as "text/*", which takes precedence over
+// "*/*".
+func (p *Policy) mediaTypePolicy(header http.Header) (MediaTypePolicy, bool) {
+	if len(p.MediaTypes) == 0 {
+		return MediaTypePolicy{}, false
+	}
+
+	mediaType := contentType(header)
+
+	if mediaType != "" {
+		if mtp, ok := p.MediaTypes[mediaType]; ok {
+			return mtp, true
+		}
+
+		if i := strings.IndexByte(mediaType, '/'); i > 0 {
+			if mtp, ok := p.MediaTypes[mediaType[:i]+"/*"]; ok {
+				return mtp, true
+			}
+		}
+	}
+
+	mtp, ok := p.MediaTypes["*/*"]
+
+	return mtp, ok
+}
+
+// contentType returns the lowercase media type of the given header's
+// Content-Type, without parameters, or an empty string if it is missing or
+// invalid.
+func contentType(header http.Header) string {
+	value := header.Get("Content-Type")
+	if value == "" {
+		return ""
+	}
+
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+
+	return mediaType
+}
@@ -2,9 +2,12 @@ package memorycachex
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"git.sr.ht/~jamesponddotco/pagecache-go"
@@ -13,16 +16,43 @@ import (
 // MemoryCache is an in-memory cache implementing the Cache interface.
 type MemoryCache struct {
 	cache       map[string]*Entry
+	tags        map[string]map[string]struct{}
+	urls        map[string]map[string]struct{}
 	policy      *pagecache.Policy
 	capacity    uint64
 	currentSize uint64
+	stats       stats
+	events      atomic.Pointer[pagecache.Dispatcher]
 	mu          sync.RWMutex
 }
 
+// stats holds the counters reported by MemoryCache.Stats.
+type stats struct {
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	expirations   atomic.Uint64
+	storeFailures atomic.Uint64
+}
+
 // Compile-time check to ensure Cache implements the cachex.Cache interface.
 var _ pagecache.Cache = (*MemoryCache)(nil)
 
-// NewCache creates a new MemoryCache instance with the specified policy and capacity.
+// Compile-time checks to ensure Cache implements the optional cachex
+// interfaces.
+var (
+	_ pagecache.Tagger      = (*MemoryCache)(nil)
+	_ pagecache.URLPurger   = (*MemoryCache)(nil)
+	_ pagecache.MatchPurger = (*MemoryCache)(nil)
+	_ pagecache.Lister      = (*MemoryCache)(nil)
+	_ pagecache.Inspector   = (*MemoryCache)(nil)
+	_ pagecache.Sizer       = (*MemoryCache)(nil)
+	_ pagecache.Stater      = (*MemoryCache)(nil)
+)
+
+// NewCache creates a new MemoryCache instance with the specified policy and
+// capacity, the maximum number of entries the cache holds before evicting the
+// least frequently used ones.
 func NewCache(policy *pagecache.Policy, capacity uint64) *MemoryCache {
 	if policy == nil {
 		policy = pagecache.DefaultPolicy()
@@ -34,54 +64,119 @@ func NewCache(policy *pagecache.Policy, capacity uint64) *MemoryCache {
 
 	return &MemoryCache{
 		cache:    make(map[string]*Entry, capacity),
+		tags:     make(map[string]map[string]struct{}),
+		urls:     make(map[string]map[string]struct{}),
 		policy:   policy,
 		capacity: capacity,
 		mu:       sync.RWMutex{},
 	}
 }
 
-func (mc *MemoryCache) Get(_ context.Context, key string) (*http.Response, error) {
+func (mc *MemoryCache) Get(ctx context.Context, key string) (*http.Response, error) {
+	start := time.Now()
+
 	mc.mu.RLock()
 	entry, found := mc.cache[key]
 	mc.mu.RUnlock()
 
 	if !found {
+		mc.miss(key, start)
+
+		if mc.variants(ctx) {
+			return nil, pagecache.ErrVaryMiss
+		}
+
 		return nil, pagecache.ErrCacheMiss
 	}
 
+	if canonical, ok := pagecache.CanonicalKeyFromContext(ctx); ok && !entry.Verify(canonical) {
+		mc.miss(key, start)
+
+		return nil, pagecache.ErrKeyCollision
+	}
+
 	if entry.IsExpired() {
 		mc.mu.Lock()
-		delete(mc.cache, key)
+		if mc.cache[key] == entry {
+			mc.drop(key)
+			mc.stats.expirations.Add(1)
+			mc.emit(pagecache.EventExpire, entry, 0)
+		}
 		mc.mu.Unlock()
 
-		return nil, pagecache.ErrCacheMiss
+		mc.miss(key, start)
+
+		return nil, pagecache.ErrCacheExpired
 	}
 
 	entry.Access()
+	mc.stats.hits.Add(1)
 
 	response, err := entry.Load(key)
 	if err != nil {
+		mc.fail(key, err)
+
 		return nil, err
 	}
 
+	mc.emit(pagecache.EventHit, entry, time.Since(start))
+
 	return response, nil
 }
 
-func (mc *MemoryCache) Set(_ context.Context, key string, response *http.Response, expiration time.Duration) error {
+func (mc *MemoryCache) Set(ctx context.Context, key string, response *http.Response, expiration time.Duration) error {
 	if !mc.policy.IsCacheable(response) { //nolint:contextcheck // we don't actually use the context for this package
 		return nil
 	}
 
-	entry, err := NewEntry(key, response, time.Now().Add(expiration))
+	// Partial responses would be served in place of the complete one, so
+	// they are only stored as the segments combined by Transport.
+	if response.StatusCode == http.StatusPartialContent && !pagecache.SegmentsFromContext(ctx) {
+		return nil
+	}
+
+	start := time.Now()
+
+	// Content-Length may be missing, so the size limit is also enforced on the
+	// bytes actually read. Responses crossing it aren't cached, just like
+	// those declaring a larger Content-Length, and are left intact.
+	if _, err := pagecache.ReadBody(response, mc.policy.BodySizeLimit(response)); err != nil { //nolint:contextcheck // see above
+		if errors.Is(err, pagecache.ErrBodyTooLarge) {
+			return nil
+		}
+
+		mc.stats.storeFailures.Add(1)
+		mc.fail(key, err)
+
+		return fmt.Errorf("%w", err)
+	}
+
+	stored := mc.policy.Sanitize(response)
+
+	entry, err := NewEntry(key, stored, time.Now().Add(expiration))
+
+	// Storing the sanitized copy may replace the body it shares with the
+	// original response, so hand the replayable body back to the caller.
+	response.Body = stored.Body
+
 	if err != nil {
+		mc.stats.storeFailures.Add(1)
+		mc.fail(key, err)
+
 		return err
 	}
 
+	entry.CanonicalKey, _ = pagecache.CanonicalKeyFromContext(ctx)
+	entry.Tags = mc.policy.Tags(response)
+	entry.URL = pagecache.NormalizeURL(response.Request.URL)
+
 	mc.mu.Lock()
-	mc.cache[key] = entry
+	mc.remove(key, pagecache.EvictReplace)
+	mc.add(key, entry)
+	mc.emit(pagecache.EventStore, entry, time.Since(start))
 	mc.mu.Unlock()
 
-	mc.evict()
+	mc.evict(key)
 
 	return nil
 }
@@ -94,7 +189,7 @@ func (mc *MemoryCache) Delete(_ context.Context, key string) error {
 		return pagecache.ErrCacheMiss
 	}
 
-	delete(mc.cache, key)
+	mc.remove(key, pagecache.EvictDelete)
 
 	return nil
 }
@@ -107,37 +202,303 @@ func (mc *MemoryCache) Purge(_ context.Context) error {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 
+	if mc.events.Load() != nil {
+		for _, entry := range mc.cache {
+			mc.emit(pagecache.EventEvict, entry, 0, pagecache.EvictPurge)
+		}
+	}
+
 	mc.cache = make(map[string]*Entry)
+	mc.tags = make(map[string]map[string]struct{})
+	mc.urls = make(map[string]map[string]struct{})
+	mc.currentSize = 0
 
 	return nil
 }
 
-func (mc *MemoryCache) evict() {
+// PurgeTags removes every cache entry associated with any of the given tags.
+func (mc *MemoryCache) PurgeTags(_ context.Context, tags ...string) error {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 
-	if mc.capacity == 0 {
+	for _, tag := range tags {
+		for key := range mc.tags[tag] {
+			mc.remove(key, pagecache.EvictTag)
+		}
+	}
+
+	return nil
+}
+
+// PurgeURL removes every cache entry stored for the given normalized URL.
+func (mc *MemoryCache) PurgeURL(_ context.Context, url string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for key := range mc.urls[url] {
+		mc.remove(key, pagecache.EvictURL)
+	}
+
+	return nil
+}
+
+// PurgeMatching removes every cache entry whose URL matches the given matcher.
+func (mc *MemoryCache) PurgeMatching(_ context.Context, matcher pagecache.URLMatcher) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for url, keys := range mc.urls {
+		if !matcher.MatchURL(url) {
+			continue
+		}
+
+		for key := range keys {
+			mc.remove(key, pagecache.EvictURL)
+		}
+	}
+
+	return nil
+}
+
+// Range calls fn for each entry in the cache until fn returns false. Entries
+// are listed from a snapshot taken when Range is called.
+func (mc *MemoryCache) Range(_ context.Context, fn func(info pagecache.EntryInfo) bool) error {
+	mc.mu.RLock()
+
+	infos := make([]pagecache.EntryInfo, 0, len(mc.cache))
+	for _, entry := range mc.cache {
+		infos = append(infos, entry.Info())
+	}
+
+	mc.mu.RUnlock()
+
+	for _, info := range infos {
+		if !fn(info) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Inspect returns the metadata of the entry associated with the given key,
+// without decoding its response.
+func (mc *MemoryCache) Inspect(_ context.Context, key string) (pagecache.EntryInfo, error) {
+	mc.mu.RLock()
+	entry, found := mc.cache[key]
+	mc.mu.RUnlock()
+
+	if !found {
+		return pagecache.EntryInfo{}, pagecache.ErrCacheMiss
+	}
+
+	return entry.Info(), nil
+}
+
+// Len returns the number of entries in the cache.
+func (mc *MemoryCache) Len(_ context.Context) (int, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	return len(mc.cache), nil
+}
+
+// Bytes returns the total size of the entries in the cache, in bytes.
+func (mc *MemoryCache) Bytes(_ context.Context) (uint64, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	return mc.currentSize, nil
+}
+
+// Stats returns the cache counters.
+func (mc *MemoryCache) Stats(_ context.Context) (pagecache.Stats, error) {
+	return pagecache.Stats{
+		Hits:          mc.stats.hits.Load(),
+		Misses:        mc.stats.misses.Load(),
+		Evictions:     mc.stats.evictions.Load(),
+		Expirations:   mc.stats.expirations.Load(),
+		StoreFailures: mc.stats.storeFailures.Load(),
+	}, nil
+}
+
+// evict removes entries until the number of entries is within the cache's
+// capacity. The entry stored under the given key, which was just added, is
+// never evicted.
+func (mc *MemoryCache) evict(added string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.capacity == 0 || uint64(len(mc.cache)) <= mc.capacity {
 		return
 	}
 
 	evictionCandidates := make([]*Entry, 0, len(mc.cache))
 
-	for _, entry := range mc.cache {
-		evictionCandidates = append(evictionCandidates, entry)
+	for key, entry := range mc.cache {
+		if key != added {
+			evictionCandidates = append(evictionCandidates, entry)
+		}
 	}
 
 	// Sort entries based on the Mockingjay cache replacement policy.
 	sort.Slice(evictionCandidates, func(i, j int) bool {
-		return evictionCandidates[i].Frequency < evictionCandidates[j].Frequency
+		return atomic.LoadUint64(&evictionCandidates[i].Frequency) < atomic.LoadUint64(&evictionCandidates[j].Frequency)
 	})
 
-	// Evict entries until the cache size is below its capacity.
+	// Evict entries until the cache size is within its capacity.
 	for _, entry := range evictionCandidates {
-		if mc.currentSize < mc.capacity {
+		if uint64(len(mc.cache)) <= mc.capacity {
 			break
 		}
 
-		mc.currentSize -= entry.Size
-		delete(mc.cache, entry.Key)
+		mc.remove(entry.Key, pagecache.EvictCapacity)
+		mc.stats.evictions.Add(1)
+	}
+}
+
+// variants reports whether a complete response to a GET request is stored for
+// the URL of the request carried by the given context, if any, that its Vary
+// header selects for other request header values.
+func (mc *MemoryCache) variants(ctx context.Context) bool {
+	req, ok := pagecache.RequestFromContext(ctx)
+	if !ok || req.URL == nil {
+		return false
+	}
+
+	url := pagecache.NormalizeURL(req.URL)
+
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	for key := range mc.urls[url] {
+		entry := mc.cache[key]
+
+		if entry.Method != http.MethodGet || entry.StatusCode == http.StatusPartialContent || entry.Vary == nil {
+			continue
+		}
+
+		if !pagecache.MatchVariant(entry.Vary, req) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Observe sets the observer notified of the cache activity, replacing any
+// previous one. Events are delivered asynchronously, in order for each key, by
+// goroutines Close stops. A nil observer stops notifications.
+func (mc *MemoryCache) Observe(observer *pagecache.Observer) {
+	mc.events.Swap(pagecache.NewDispatcher(observer)).Close()
+}
+
+// Close delivers the pending events to the observer and stops notifying it.
+func (mc *MemoryCache) Close() {
+	mc.events.Swap(nil).Close()
+}
+
+// emit notifies the observer, if any, of an event about the given entry.
+func (mc *MemoryCache) emit(eventType pagecache.EventType, entry *Entry, duration time.Duration, reason ...pagecache.EvictReason) {
+	events := mc.events.Load()
+	if events == nil {
+		return
+	}
+
+	event := pagecache.Event{
+		Key:      entry.Key,
+		URL:      entry.URL,
+		Type:     eventType,
+		Size:     atomic.LoadUint64(&entry.Size),
+		Duration: duration,
+	}
+
+	if len(reason) > 0 {
+		event.Reason = reason[0]
+	}
+
+	events.Emit(event)
+}
+
+// miss records a cache miss for the given key.
+func (mc *MemoryCache) miss(key string, start time.Time) {
+	mc.stats.misses.Add(1)
+
+	mc.events.Load().Emit(pagecache.Event{
+		Key:      key,
+		Type:     pagecache.EventMiss,
+		Duration: time.Since(start),
+	})
+}
+
+// fail notifies the observer, if any, of an error about the given key.
+func (mc *MemoryCache) fail(key string, err error) {
+	mc.events.Load().Emit(pagecache.Event{
+		Err:  err,
+		Key:  key,
+		Type: pagecache.EventError,
+	})
+}
+
+// add stores the entry under the given key and indexes its tags. It must be
+// called with the lock held.
+func (mc *MemoryCache) add(key string, entry *Entry) {
+	mc.cache[key] = entry
+	mc.currentSize += entry.Size
+
+	for _, tag := range entry.Tags {
+		addIndex(mc.tags, tag, key)
+	}
+
+	addIndex(mc.urls, entry.URL, key)
+}
+
+// remove deletes the entry associated with the given key, if any, and emits
+// an eviction event with the given reason. It must be called with the lock
+// held.
+func (mc *MemoryCache) remove(key string, reason pagecache.EvictReason) {
+	if entry := mc.drop(key); entry != nil {
+		mc.emit(pagecache.EventEvict, entry, 0, reason)
+	}
+}
+
+// drop deletes the entry associated with the given key, if any, and its tag
+// and URL associations, and returns it. It must be called with the lock held.
+func (mc *MemoryCache) drop(key string) *Entry {
+	entry, found := mc.cache[key]
+	if !found {
+		return nil
+	}
+
+	for _, tag := range entry.Tags {
+		removeIndex(mc.tags, tag, key)
+	}
+
+	removeIndex(mc.urls, entry.URL, key)
+
+	mc.currentSize -= entry.Size
+	delete(mc.cache, key)
+
+	return entry
+}
+
+// addIndex associates the given key with the given value in a reverse index.
+func addIndex(index map[string]map[string]struct{}, value, key string) {
+	if index[value] == nil {
+		index[value] = make(map[string]struct{})
+	}
+
+	index[value][key] = struct{}{}
+}
+
+// removeIndex removes the association between the given key and the given
+// value from a reverse index.
+func removeIndex(index map[string]map[string]struct{}, value, key string) {
+	keys := index[value]
+
+	delete(keys, key)
+
+	if len(keys) == 0 {
+		delete(index, value)
 	}
 }
@@ -1,8 +1,15 @@
 package memorycachex_test
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"git.sr.ht/~jamesponddotco/pagecache-go"
 	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
@@ -80,3 +87,507 @@ func TestNewCache(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryCache_Set_StripExcluded(t *testing.T) {
+	t.Parallel()
+
+	// Keep the cookies that aren't excluded.
+	policy := pagecache.DefaultPolicy()
+	delete(policy.StrippedHeaders, "Set-Cookie")
+	policy.StripExcluded = true
+	policy.ExcludedCookies["_ga"] = struct{}{}
+
+	cache := memorycachex.NewCache(policy, 0)
+
+	resp := createValidResponse(t)
+	resp.Header.Add("Set-Cookie", "_ga=1")
+	resp.Header.Add("Set-Cookie", "theme=dark")
+
+	if err := cache.Set(context.Background(), "testkey", resp, time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	if got := len(resp.Header.Values("Set-Cookie")); got != 2 {
+		t.Errorf("Set() modified the caller's response, got %d Set-Cookie lines", got)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "OK" {
+		t.Errorf("Set() consumed the caller's body, got %q, %v", body, err)
+	}
+
+	cached, err := cache.Get(context.Background(), "testkey")
+	if err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+
+	if got := cached.Header.Values("Set-Cookie"); !reflect.DeepEqual(got, []string{"theme=dark"}) {
+		t.Errorf("Get() Set-Cookie = %v, want [theme=dark]", got)
+	}
+}
+
+func TestMemoryCache_Set_BodyTooLarge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStored bool
+	}{
+		{
+			name:       "Within limit",
+			body:       strings.Repeat("a", 10),
+			wantStored: true,
+		},
+		{
+			name: "Larger than limit",
+			body: strings.Repeat("a", 11),
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			policy := pagecache.DefaultPolicy()
+			policy.MaxBodySize = 10
+
+			cache := memorycachex.NewCache(policy, 0)
+
+			// Without Content-Length, only the bytes read reveal the size.
+			resp := createValidResponse(t)
+			resp.Header.Del("Content-Length")
+			resp.ContentLength = -1
+			resp.Body = io.NopCloser(strings.NewReader(tt.body))
+
+			if err := cache.Set(context.Background(), "testkey", resp, time.Minute); err != nil {
+				t.Fatalf("Set() unexpected error: %v", err)
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil || string(body) != tt.body {
+				t.Errorf("Set() altered the caller's body, got %q, %v", body, err)
+			}
+
+			_, err = cache.Get(context.Background(), "testkey")
+			if stored := err == nil; stored != tt.wantStored {
+				t.Errorf("Get() error = %v, want stored %v", err, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestMemoryCache_Set_PartialContent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		segments   bool
+		wantStored bool
+	}{
+		{
+			name:       "Plain context",
+			wantStored: false,
+		},
+		{
+			name:       "Segments",
+			segments:   true,
+			wantStored: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if tt.segments {
+				ctx = pagecache.WithSegments(ctx)
+			}
+
+			cache := memorycachex.NewCache(nil, 0)
+
+			resp := createValidResponse(t)
+			resp.StatusCode = http.StatusPartialContent
+			resp.Header.Set("Content-Range", "bytes 0-1/10")
+
+			if err := cache.Set(ctx, "partial", resp, time.Minute); err != nil {
+				t.Fatalf("Set() unexpected error: %v", err)
+			}
+
+			_, err := cache.Get(ctx, "partial")
+			if stored := err == nil; stored != tt.wantStored {
+				t.Errorf("Get() error = %v, want stored: %v", err, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestMemoryCache_Get_KeyCollision(t *testing.T) {
+	t.Parallel()
+
+	cache := memorycachex.NewCache(nil, 0)
+
+	ctx := pagecache.WithCanonicalKey(context.Background(), "name:httpx:method:GET:url:https://example.com/a")
+	if err := cache.Set(ctx, "collision", createValidResponse(t), time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	if _, err := cache.Get(ctx, "collision"); err != nil {
+		t.Errorf("Get() with the same canonical key returned error: %v", err)
+	}
+
+	other := pagecache.WithCanonicalKey(context.Background(), "name:httpx:method:GET:url:https://example.com/b")
+	if _, err := cache.Get(other, "collision"); !errors.Is(err, pagecache.ErrKeyCollision) {
+		t.Errorf("Get() with a different canonical key returned %v, want %v", err, pagecache.ErrKeyCollision)
+	}
+
+	if _, err := cache.Get(context.Background(), "collision"); err != nil {
+		t.Errorf("Get() without a canonical key returned error: %v", err)
+	}
+}
+
+func TestMemoryCache_Get_VaryMiss(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		store   func(resp *http.Response)
+		wantErr error
+	}{
+		{
+			name:    "No entry for the URL",
+			wantErr: pagecache.ErrCacheMiss,
+		},
+		{
+			name:    "GET entry without Vary",
+			store:   func(*http.Response) {},
+			wantErr: pagecache.ErrCacheMiss,
+		},
+		{
+			name: "GET entry for the same variant",
+			store: func(resp *http.Response) {
+				resp.Header.Set("Vary", "Accept-Language")
+				resp.Header.Set(pagecache.VariantHeader, `Accept-Language="fr"`)
+			},
+			wantErr: pagecache.ErrCacheMiss,
+		},
+		{
+			name: "GET entry for another variant",
+			store: func(resp *http.Response) {
+				resp.Header.Set("Vary", "Accept-Language")
+				resp.Header.Set(pagecache.VariantHeader, `Accept-Language="en"`)
+			},
+			wantErr: pagecache.ErrVaryMiss,
+		},
+		{
+			name: "HEAD entry for another variant",
+			store: func(resp *http.Response) {
+				resp.Request.Method = http.MethodHead
+				resp.Header.Set("Vary", "Accept-Language")
+				resp.Header.Set(pagecache.VariantHeader, `Accept-Language="en"`)
+			},
+			wantErr: pagecache.ErrCacheMiss,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cache := memorycachex.NewCache(nil, 0)
+
+			if tt.store != nil {
+				resp := createValidResponse(t)
+				tt.store(resp)
+
+				if err := cache.Set(context.Background(), "stored", resp, time.Minute); err != nil {
+					t.Fatalf("Set() unexpected error: %v", err)
+				}
+			}
+
+			req, err := http.NewRequest(http.MethodGet, "http://example.com/", http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header.Set("Accept-Language", "fr")
+
+			_, err = cache.Get(pagecache.WithRequest(context.Background(), req), "other")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != pagecache.ErrVaryMiss && errors.Is(err, pagecache.ErrVaryMiss) { //nolint:errorlint // comparing the expected sentinel
+				t.Errorf("Get() error = %v, want a plain %v", err, pagecache.ErrCacheMiss)
+			}
+		})
+	}
+}
+
+func TestMemoryCache_PurgeTags(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		cache = memorycachex.NewCache(nil, 0)
+		tags  = map[string]string{
+			"article-1": "article-1 author-1",
+			"article-2": "article-2 author-1",
+			"article-3": "article-3 author-2",
+			"home":      "home",
+		}
+	)
+
+	for key, surrogateKey := range tags {
+		resp := createValidResponse(t)
+		resp.Header.Set("Surrogate-Key", surrogateKey)
+
+		if err := cache.Set(ctx, key, resp, time.Minute); err != nil {
+			t.Fatalf("Set() unexpected error: %v", err)
+		}
+	}
+
+	// Replacing an entry drops its previous tags.
+	resp := createValidResponse(t)
+	resp.Header.Set("Cache-Tag", "home,featured")
+
+	if err := cache.Set(ctx, "article-3", resp, time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	if err := cache.PurgeTags(ctx, "author-1", "author-2"); err != nil {
+		t.Fatalf("PurgeTags() unexpected error: %v", err)
+	}
+
+	for key, want := range map[string]bool{
+		"article-1": false,
+		"article-2": false,
+		"article-3": true,
+		"home":      true,
+	} {
+		if _, err := cache.Get(ctx, key); (err == nil) != want {
+			t.Errorf("Get(%q) error = %v, want found: %v", key, err, want)
+		}
+	}
+
+	if err := pagecache.PurgeTags(ctx, cache, "home"); err != nil {
+		t.Fatalf("PurgeTags() unexpected error: %v", err)
+	}
+
+	for _, key := range []string{"article-3", "home"} {
+		if _, err := cache.Get(ctx, key); !errors.Is(err, pagecache.ErrCacheMiss) {
+			t.Errorf("Get(%q) error = %v, want %v", key, err, pagecache.ErrCacheMiss)
+		}
+	}
+}
+
+func TestMemoryCache_Stats(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		cache = memorycachex.NewCache(nil, 2)
+	)
+
+	for _, key := range []string{"a", "b"} {
+		if err := cache.Set(ctx, key, createValidResponse(t), time.Minute); err != nil {
+			t.Fatalf("Set() unexpected error: %v", err)
+		}
+	}
+
+	// Make "b" more frequently used than "a", so "a" is evicted first.
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get(ctx, "b"); err != nil {
+			t.Fatalf("Get() unexpected error: %v", err)
+		}
+	}
+
+	if _, err := cache.Get(ctx, "missing"); err == nil {
+		t.Fatal("Get() expected error, got nil")
+	}
+
+	if err := cache.Set(ctx, "c", createValidResponse(t), time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	if err := cache.Set(ctx, "d", createValidResponse(t), -time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	if _, err := cache.Get(ctx, "d"); err == nil {
+		t.Fatal("Get() of expired entry expected error, got nil")
+	}
+
+	// "a" and "c" were evicted to make room, and "d" expired.
+	n, err := pagecache.Len(ctx, cache)
+	if err != nil || n != 1 {
+		t.Errorf("Len() = %d, %v, want 1", n, err)
+	}
+
+	size, err := pagecache.Bytes(ctx, cache)
+	if err != nil || size == 0 {
+		t.Errorf("Bytes() = %d, %v, want non-zero", size, err)
+	}
+
+	var keys []string
+
+	err = pagecache.Range(ctx, cache, func(info pagecache.EntryInfo) bool {
+		keys = append(keys, info.Key)
+
+		if info.URL != "http://example.com" {
+			t.Errorf("Range() URL = %q, want %q", info.URL, "http://example.com")
+		}
+
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Range() unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Errorf("Range() keys = %v, want [b]", keys)
+	}
+
+	stats, err := pagecache.ReadStats(ctx, cache)
+	if err != nil {
+		t.Fatalf("ReadStats() unexpected error: %v", err)
+	}
+
+	want := pagecache.Stats{
+		Hits:        2,
+		Misses:      2,
+		Evictions:   2,
+		Expirations: 1,
+	}
+
+	if stats != want {
+		t.Errorf("ReadStats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestMemoryCache_Inspect(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		cache = memorycachex.NewCache(nil, 0)
+	)
+
+	resp := createValidResponse(t)
+	resp.Header.Set("ETag", `W/"abc"`)
+
+	if err := cache.Set(ctx, "testkey", resp, time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	if _, err := cache.Get(ctx, "testkey"); err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+
+	info, err := pagecache.Inspect(ctx, cache, "testkey")
+	if err != nil {
+		t.Fatalf("Inspect() unexpected error: %v", err)
+	}
+
+	if info.ETag != `W/"abc"` || info.Hits != 1 || info.LastAccess.IsZero() || info.URL != "http://example.com" {
+		t.Errorf("Inspect() = %+v, want ETag, hit count, last access and URL set", info)
+	}
+
+	if _, err := pagecache.Inspect(ctx, cache, "missing"); !errors.Is(err, pagecache.ErrCacheMiss) {
+		t.Errorf("Inspect() error = %v, want %v", err, pagecache.ErrCacheMiss)
+	}
+}
+
+func TestMemoryCache_Observe(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx    = context.Background()
+		cache  = memorycachex.NewCache(nil, 1)
+		mu     sync.Mutex
+		events []string
+	)
+
+	record := func(event pagecache.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		name := event.Type.String() + " " + event.Key
+		if event.Type == pagecache.EventEvict {
+			name += " " + event.Reason.String()
+		}
+
+		events = append(events, name)
+	}
+
+	cache.Observe(&pagecache.Observer{
+		OnHit:    record,
+		OnMiss:   record,
+		OnStore:  record,
+		OnEvict:  record,
+		OnExpire: record,
+	})
+
+	steps := []func() error{
+		func() error { return cache.Set(ctx, "a", createValidResponse(t), time.Minute) },
+		func() error { _, err := cache.Get(ctx, "a"); return err },
+		func() error { return cache.Set(ctx, "a", createValidResponse(t), -time.Minute) },
+		func() error { _, err := cache.Get(ctx, "a"); return ignoreMiss(err) },
+		func() error { return cache.Set(ctx, "a", createValidResponse(t), time.Minute) },
+		func() error { return cache.Set(ctx, "b", createValidResponse(t), time.Minute) },
+		func() error { return cache.Delete(ctx, "b") },
+	}
+
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d unexpected error: %v", i, err)
+		}
+	}
+
+	cache.Close()
+
+	// Events are only ordered per key, so compare them per key.
+	want := map[string][]string{
+		"a": {
+			"store a",
+			"hit a",
+			"evict a replace",
+			"store a",
+			"expire a",
+			"miss a",
+			"store a",
+			"evict a capacity",
+		},
+		"b": {
+			"store b",
+			"evict b delete",
+		},
+	}
+
+	got := make(map[string][]string)
+
+	for _, event := range events {
+		key := strings.Fields(event)[1]
+		got[key] = append(got[key], event)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+// ignoreMiss returns nil if err is a cache miss, and err otherwise.
+func ignoreMiss(err error) error {
+	if errors.Is(err, pagecache.ErrCacheMiss) {
+		return nil
+	}
+
+	return err
+}
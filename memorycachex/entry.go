@@ -22,12 +22,30 @@ const (
 // Entry represents a single cache entry. Entry is not tread-safe and should be
 // protected by a sync.Mutex.
 type Entry struct {
-	Key        string
-	Expiration time.Time
-	Request    []byte
-	Response   []byte
-	Size       uint64
-	Frequency  uint64
+	StoredAt     time.Time
+	Expiration   time.Time
+	LastModified time.Time
+	Key          string
+	CanonicalKey string
+	URL          string
+	Method       string
+	ETag         string
+	Request      []byte
+	Response     []byte
+	Body         []byte
+	Tags         []string
+	StatusCode   int
+	Size         uint64
+	Frequency    uint64
+
+	// Vary holds the Vary header of the response and the
+	// pagecache.VariantHeader recording the request header values it
+	// selects, if any, or is nil if the response has no Vary header.
+	Vary http.Header
+
+	// LastAccess is the time of the last access, in nanoseconds since the
+	// Unix epoch, or zero if the entry was never accessed.
+	LastAccess int64
 }
 
 // Compile-time check to ensure Entry implements the cachex.Entry interface.
@@ -49,30 +67,65 @@ func NewEntry(key string, resp *http.Response, expiration time.Time) (*Entry, er
 		return nil, ErrExpirationZero
 	}
 
-	request, response, err := pagecache.SaveResponse(resp)
+	// The body is kept apart from the serialized response, so a body already
+	// held in memory is stored without being copied.
+	body, err := pagecache.ReadBody(resp, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMarshalResponse, err)
+	}
+
+	request, response, err := pagecache.SaveResponseHead(resp, int64(len(body)))
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrMarshalResponse, err)
 	}
 
 	entry := &Entry{
-		Key:        key,
+		StoredAt:   time.Now(),
 		Expiration: expiration,
+		Key:        key,
+		Method:     resp.Request.Method,
+		ETag:       resp.Header.Get("ETag"),
 		Request:    request,
 		Response:   response,
-		Size:       0,
+		Body:       body,
+		StatusCode: resp.StatusCode,
+		Size:       uint64(len(request) + len(response) + len(body)),
 		Frequency:  0,
 	}
 
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		entry.LastModified = lastModified
+	}
+
+	if vary := resp.Header.Values("Vary"); len(vary) > 0 {
+		entry.Vary = http.Header{"Vary": append([]string(nil), vary...)}
+
+		if variant := resp.Header.Get(pagecache.VariantHeader); variant != "" {
+			entry.Vary.Set(pagecache.VariantHeader, variant)
+		}
+	}
+
 	return entry, nil
 }
 
+// Verify checks if the entry was stored for the given canonical key. Entries
+// stored without a canonical key, or verified against an empty one, always
+// pass.
+func (e *Entry) Verify(canonical string) bool {
+	if e.CanonicalKey == "" || canonical == "" {
+		return true
+	}
+
+	return e.CanonicalKey == canonical
+}
+
 // Load loads the HTTP response from the cache entry.
 func (e *Entry) Load(key string) (*http.Response, error) {
 	if key != e.Key {
 		return nil, ErrKeyMismatch
 	}
 
-	resp, err := pagecache.LoadResponse(e.Request, e.Response)
+	resp, err := pagecache.LoadResponseHead(e.Request, e.Response, e.Body)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrUnmarshalResponse, err)
 	}
@@ -80,9 +133,32 @@ func (e *Entry) Load(key string) (*http.Response, error) {
 	return resp, nil
 }
 
-// Access increments the frequency counter when the entry is accessed.
+// Access increments the frequency counter and updates the last access time
+// when the entry is accessed.
 func (e *Entry) Access() {
 	atomic.AddUint64(&e.Frequency, 1)
+	atomic.StoreInt64(&e.LastAccess, time.Now().UnixNano())
+}
+
+// Info returns the metadata of the entry.
+func (e *Entry) Info() pagecache.EntryInfo {
+	info := pagecache.EntryInfo{
+		StoredAt:     e.StoredAt,
+		Expiration:   e.Expiration,
+		LastModified: e.LastModified,
+		ETag:         e.ETag,
+		Key:          e.Key,
+		URL:          e.URL,
+		StatusCode:   e.StatusCode,
+		Size:         atomic.LoadUint64(&e.Size),
+		Hits:         atomic.LoadUint64(&e.Frequency),
+	}
+
+	if lastAccess := atomic.LoadInt64(&e.LastAccess); lastAccess != 0 {
+		info.LastAccess = time.Unix(0, lastAccess)
+	}
+
+	return info
 }
 
 // SetSize updates the size of the cache entry.
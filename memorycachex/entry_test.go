@@ -1,11 +1,13 @@
 package memorycachex_test
 
 import (
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"git.sr.ht/~jamesponddotco/pagecache-go"
 	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
 )
 
@@ -301,6 +303,41 @@ func TestEntry_IsExpired(t *testing.T) {
 	}
 }
 
+func TestNewEntry_BufferedBody(t *testing.T) {
+	t.Parallel()
+
+	resp := createValidResponse(t)
+
+	body, err := pagecache.ReadBody(resp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := memorycachex.NewEntry("testkey", resp, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("NewEntry() unexpected error: %v", err)
+	}
+
+	// A body already held in memory is stored without being copied.
+	if len(entry.Body) != len(body) || &entry.Body[0] != &body[0] {
+		t.Error("NewEntry() copied the body")
+	}
+
+	loaded, err := entry.Load("testkey")
+	if err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+
+	got, err := io.ReadAll(loaded.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "OK" {
+		t.Errorf("loaded body = %q, want %q", got, "OK")
+	}
+}
+
 func createValidResponse(t *testing.T) *http.Response {
 	t.Helper()
 
@@ -329,3 +366,46 @@ func createValidEntry(t *testing.T) (*memorycachex.Entry, error) {
 
 	return memorycachex.NewEntry("testkey", resp, expiration)
 }
+
+func TestEntry_Info(t *testing.T) {
+	t.Parallel()
+
+	lastModified := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	resp := createValidResponse(t)
+	resp.Header.Set("ETag", `"v1"`)
+	resp.Header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	expiration := time.Now().Add(10 * time.Minute)
+
+	entry, err := memorycachex.NewEntry("testkey", resp, expiration)
+	if err != nil {
+		t.Fatalf("Failed to create a new entry: %v", err)
+	}
+
+	info := entry.Info()
+
+	if info.Key != "testkey" || info.ETag != `"v1"` || info.StatusCode != http.StatusOK {
+		t.Errorf("Info() = %+v, want key, ETag and status code from the response", info)
+	}
+
+	if !info.LastModified.Equal(lastModified) {
+		t.Errorf("Info().LastModified = %v, want %v", info.LastModified, lastModified)
+	}
+
+	if !info.Expiration.Equal(expiration) || info.StoredAt.IsZero() || info.Size == 0 {
+		t.Errorf("Info() = %+v, want expiration, storage time and size set", info)
+	}
+
+	if !info.LastAccess.IsZero() || info.Hits != 0 {
+		t.Errorf("Info() = %+v, want no access recorded", info)
+	}
+
+	entry.Access()
+
+	info = entry.Info()
+
+	if info.LastAccess.IsZero() || info.Hits != 1 {
+		t.Errorf("Info() after Access() = %+v, want access recorded", info)
+	}
+}
@@ -0,0 +1,246 @@
+package metricsx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+// Options configures the metrics collected by a Cache.
+type Options struct {
+	// Labeler adds an optional label, such as ByHost or ByRule, to the
+	// metrics. If nil, metrics are only labelled by cache name.
+	Labeler *Labeler
+
+	// Buckets are the upper bounds of the latency histogram buckets, in
+	// seconds. If empty, DefaultBuckets is used.
+	Buckets []float64
+}
+
+// Cache is a pagecache.Cache collecting metrics about the operations made on
+// the cache it wraps. The optional pagecache interfaces are forwarded to the
+// wrapped cache, and return pagecache.ErrUnsupported if it does not implement
+// them.
+type Cache struct {
+	cache   pagecache.Cache
+	labeler *Labeler
+	metrics *metrics
+	name    string
+}
+
+// Compile-time check to ensure Cache implements the pagecache.Cache interface.
+var _ pagecache.Cache = (*Cache)(nil)
+
+// Compile-time checks to ensure Cache implements the optional pagecache
+// interfaces.
+var (
+	_ pagecache.Tagger               = (*Cache)(nil)
+	_ pagecache.URLPurger            = (*Cache)(nil)
+	_ pagecache.MatchPurger          = (*Cache)(nil)
+	_ pagecache.Lister               = (*Cache)(nil)
+	_ pagecache.Inspector            = (*Cache)(nil)
+	_ pagecache.Sizer                = (*Cache)(nil)
+	_ pagecache.Stater               = (*Cache)(nil)
+	_ pagecache.RevalidationRecorder = (*Cache)(nil)
+)
+
+// NewCache wraps the given cache to collect metrics labelled with the given
+// name. If opts is nil, the default options are used.
+func NewCache(name string, cache pagecache.Cache, opts *Options) *Cache {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	return &Cache{
+		cache:   cache,
+		labeler: opts.Labeler,
+		metrics: newMetrics(opts.Buckets),
+		name:    name,
+	}
+}
+
+// Name returns the name of the cache.
+func (c *Cache) Name() string {
+	return c.name
+}
+
+// Unwrap returns the wrapped cache.
+func (c *Cache) Unwrap() pagecache.Cache {
+	return c.cache
+}
+
+// Samples returns a snapshot of the metrics collected so far, one sample per
+// label value, sorted by label value.
+func (c *Cache) Samples() []Sample {
+	return c.metrics.samples()
+}
+
+// RecordRevalidation implements the pagecache.RevalidationRecorder interface,
+// counting a revalidation for the request carried by the given context.
+func (c *Cache) RecordRevalidation(ctx context.Context) {
+	c.metrics.revalidation(c.label(ctx))
+}
+
+// Get implements the pagecache.Cache interface. Lookups failing with a
+// pagecache.ErrNotFound error count as misses, not errors, and those failing
+// with pagecache.ErrCacheExpired count as stale as well.
+func (c *Cache) Get(ctx context.Context, key string) (*http.Response, error) {
+	var (
+		label = c.label(ctx)
+		start = time.Now()
+	)
+
+	resp, err := c.cache.Get(ctx, key)
+
+	c.metrics.lookup(label, err == nil, errors.Is(err, pagecache.ErrCacheExpired))
+
+	var cacheErr *pagecache.Error
+	if errors.As(err, &cacheErr) && cacheErr.Type() == pagecache.ErrNotFound {
+		c.metrics.observe(label, OperationGet, time.Since(start), nil)
+	} else {
+		c.metrics.observe(label, OperationGet, time.Since(start), err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return resp, nil
+}
+
+// Set implements the pagecache.Cache interface.
+func (c *Cache) Set(ctx context.Context, key string, resp *http.Response, duration time.Duration) error {
+	return c.do(ctx, OperationSet, func() error {
+		return c.cache.Set(ctx, key, resp, duration)
+	})
+}
+
+// Delete implements the pagecache.Cache interface.
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, OperationDelete, func() error {
+		return c.cache.Delete(ctx, key)
+	})
+}
+
+// Policy implements the pagecache.Cache interface.
+func (c *Cache) Policy() *pagecache.Policy {
+	return c.cache.Policy()
+}
+
+// Purge implements the pagecache.Cache interface.
+func (c *Cache) Purge(ctx context.Context) error {
+	return c.do(ctx, OperationPurge, func() error {
+		return c.cache.Purge(ctx)
+	})
+}
+
+// PurgeTags implements the pagecache.Tagger interface.
+func (c *Cache) PurgeTags(ctx context.Context, tags ...string) error {
+	return c.do(ctx, OperationPurgeTags, func() error {
+		return pagecache.PurgeTags(ctx, c.cache, tags...)
+	})
+}
+
+// PurgeURL implements the pagecache.URLPurger interface.
+func (c *Cache) PurgeURL(ctx context.Context, url string) error {
+	purger, ok := c.cache.(pagecache.URLPurger)
+	if !ok {
+		return pagecache.ErrUnsupported
+	}
+
+	return c.do(ctx, OperationPurgeURL, func() error {
+		return purger.PurgeURL(ctx, url)
+	})
+}
+
+// PurgeMatching implements the pagecache.MatchPurger interface.
+func (c *Cache) PurgeMatching(ctx context.Context, matcher pagecache.URLMatcher) error {
+	return c.do(ctx, OperationPurgeMatching, func() error {
+		return pagecache.PurgeMatching(ctx, c.cache, matcher)
+	})
+}
+
+// Range implements the pagecache.Lister interface.
+func (c *Cache) Range(ctx context.Context, fn func(info pagecache.EntryInfo) bool) error {
+	if err := pagecache.Range(ctx, c.cache, fn); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// Inspect implements the pagecache.Inspector interface.
+func (c *Cache) Inspect(ctx context.Context, key string) (pagecache.EntryInfo, error) {
+	info, err := pagecache.Inspect(ctx, c.cache, key)
+	if err != nil {
+		return pagecache.EntryInfo{}, fmt.Errorf("%w", err)
+	}
+
+	return info, nil
+}
+
+// Len implements the pagecache.Sizer interface.
+func (c *Cache) Len(ctx context.Context) (int, error) {
+	n, err := pagecache.Len(ctx, c.cache)
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+// Bytes implements the pagecache.Sizer interface.
+func (c *Cache) Bytes(ctx context.Context) (uint64, error) {
+	n, err := pagecache.Bytes(ctx, c.cache)
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+// Stats implements the pagecache.Stater interface.
+func (c *Cache) Stats(ctx context.Context) (pagecache.Stats, error) {
+	stats, err := pagecache.ReadStats(ctx, c.cache)
+	if err != nil {
+		return pagecache.Stats{}, fmt.Errorf("%w", err)
+	}
+
+	return stats, nil
+}
+
+// do runs the given operation and records its metrics.
+func (c *Cache) do(ctx context.Context, operation string, fn func() error) error {
+	var (
+		label = c.label(ctx)
+		start = time.Now()
+		err   = fn()
+	)
+
+	c.metrics.observe(label, operation, time.Since(start), err)
+
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// label returns the value of the optional label for the request carried by
+// the given context.
+func (c *Cache) label(ctx context.Context) string {
+	if c.labeler == nil {
+		return ""
+	}
+
+	req, ok := pagecache.RequestFromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	return c.labeler.Func(c.cache.Policy(), req)
+}
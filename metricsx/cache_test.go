@@ -0,0 +1,254 @@
+package metricsx_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+	"git.sr.ht/~jamesponddotco/pagecache-go/metricsx"
+)
+
+func TestCache_Samples(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		labeler *metricsx.Labeler
+		rules   []*pagecache.Rule
+		want    map[string]metricsx.Sample
+	}{
+		{
+			name: "Unlabelled",
+			want: map[string]metricsx.Sample{
+				"": {Hits: 1, Misses: 3, Stale: 1, Revalidations: 1},
+			},
+		},
+		{
+			name:    "By host",
+			labeler: metricsx.ByHost,
+			want: map[string]metricsx.Sample{
+				"a.example.com": {Hits: 1, Misses: 2, Stale: 1, Revalidations: 1},
+				"b.example.com": {Misses: 1},
+			},
+		},
+		{
+			name:    "By rule",
+			labeler: metricsx.ByRule,
+			rules: []*pagecache.Rule{
+				{Pattern: `^http://a\.example\.com/`, Behavior: pagecache.BehaviorInclude},
+			},
+			want: map[string]metricsx.Sample{
+				`^http://a\.example\.com/`: {Hits: 1, Misses: 2, Stale: 1, Revalidations: 1},
+				metricsx.NoRule:            {Misses: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			policy := pagecache.DefaultPolicy()
+			policy.Rules = tt.rules
+
+			cache := metricsx.NewCache("test", memorycachex.NewCache(policy, 0), &metricsx.Options{
+				Labeler: tt.labeler,
+			})
+
+			ctxA := requestContext(t, "http://a.example.com/page")
+			ctxB := requestContext(t, "http://b.example.com/page")
+
+			if _, err := cache.Get(ctxA, "a"); err == nil {
+				t.Fatal("Get() expected error, got nil")
+			}
+
+			if err := cache.Set(ctxA, "a", newTestResponse(t, "http://a.example.com/page"), time.Minute); err != nil {
+				t.Fatalf("Set() unexpected error: %v", err)
+			}
+
+			if _, err := cache.Get(ctxA, "a"); err != nil {
+				t.Fatalf("Get() unexpected error: %v", err)
+			}
+
+			if _, err := cache.Get(ctxB, "b"); err == nil {
+				t.Fatal("Get() expected error, got nil")
+			}
+
+			if err := cache.Set(ctxA, "expired", newTestResponse(t, "http://a.example.com/page"), -time.Minute); err != nil {
+				t.Fatalf("Set() unexpected error: %v", err)
+			}
+
+			if _, err := cache.Get(ctxA, "expired"); !errors.Is(err, pagecache.ErrCacheExpired) {
+				t.Fatalf("Get() error = %v, want %v", err, pagecache.ErrCacheExpired)
+			}
+
+			cache.RecordRevalidation(ctxA)
+
+			samples := cache.Samples()
+			if len(samples) != len(tt.want) {
+				t.Fatalf("Samples() returned %d samples, want %d", len(samples), len(tt.want))
+			}
+
+			for _, got := range samples {
+				want, ok := tt.want[got.Label]
+				if !ok {
+					t.Fatalf("Samples() unexpected label %q", got.Label)
+				}
+
+				if got.Hits != want.Hits || got.Misses != want.Misses || got.Stale != want.Stale || got.Revalidations != want.Revalidations {
+					t.Errorf("Samples()[%q] = %+v, want %+v", got.Label, got, want)
+				}
+
+				// Misses are not errors.
+				if errs := got.Operations[metricsx.OperationGet].Errors; len(errs) != 0 {
+					t.Errorf("Samples()[%q] get errors = %v, want none", got.Label, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestCache_TransportRevalidations(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("page"))
+	}))
+	defer ts.Close()
+
+	var (
+		cache     = metricsx.NewCache("test", memorycachex.NewCache(nil, 0), nil)
+		transport = pagecache.NewTransport(cache, ts.Client().Transport)
+	)
+
+	do := func(method string, header http.Header) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, ts.URL+"/page", http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header = header
+
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip() unexpected error: %v", err)
+		}
+
+		// The response is only stored once its body was read.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// Store an already expired response, which the next request replaces.
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/page", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cache.Set(context.Background(), transport.Key(req), resp, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	do(http.MethodGet, http.Header{})
+
+	// Responses to HEAD requests forwarded to the origin refresh the stored
+	// GET response.
+	do(http.MethodHead, http.Header{"Cache-Control": {"no-cache"}})
+
+	samples := cache.Samples()
+	if len(samples) != 1 {
+		t.Fatalf("Samples() returned %d samples, want 1", len(samples))
+	}
+
+	if got := samples[0]; got.Stale != 1 || got.Revalidations != 2 {
+		t.Errorf("Samples() = %+v, want 1 stale entry and 2 revalidations", got)
+	}
+}
+
+func TestCache_Errors(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		cache = metricsx.NewCache("test", pagecache.NewNamespace(memorycachex.NewCache(nil, 0), "ns", 0), nil)
+	)
+
+	if err := cache.Delete(ctx, "missing"); !errors.Is(err, pagecache.ErrCacheMiss) {
+		t.Fatalf("Delete() error = %v, want %v", err, pagecache.ErrCacheMiss)
+	}
+
+	// Namespace does not implement the optional interfaces.
+	if err := cache.PurgeTags(ctx, "tag"); !errors.Is(err, pagecache.ErrUnsupported) {
+		t.Fatalf("PurgeTags() error = %v, want %v", err, pagecache.ErrUnsupported)
+	}
+
+	if err := cache.PurgeURL(ctx, "http://example.com/"); !errors.Is(err, pagecache.ErrUnsupported) {
+		t.Fatalf("PurgeURL() error = %v, want %v", err, pagecache.ErrUnsupported)
+	}
+
+	samples := cache.Samples()
+	if len(samples) != 1 {
+		t.Fatalf("Samples() returned %d samples, want 1", len(samples))
+	}
+
+	ops := samples[0].Operations
+
+	if got := ops[metricsx.OperationDelete]; got.Count != 1 || got.Errors[pagecache.ErrNotFound.String()] != 1 {
+		t.Errorf("delete = %+v, want one %q error", got, pagecache.ErrNotFound)
+	}
+
+	if got := ops[metricsx.OperationPurgeTags]; got.Count != 1 || got.Errors[pagecache.ErrOperationFailed.String()] != 1 {
+		t.Errorf("purge_tags = %+v, want one %q error", got, pagecache.ErrOperationFailed)
+	}
+
+	if _, ok := ops[metricsx.OperationPurgeURL]; ok {
+		t.Error("purge_url recorded, want unsupported URL purges to be skipped")
+	}
+}
+
+func requestContext(t *testing.T, rawURL string) context.Context {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pagecache.WithRequest(context.Background(), req)
+}
+
+func newTestResponse(t *testing.T, rawURL string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(http.StatusOK)
+	rec.WriteString("OK")
+
+	resp := rec.Result()
+	resp.Request = req
+
+	return resp
+}
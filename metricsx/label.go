@@ -0,0 +1,52 @@
+package metricsx
+
+import (
+	"net/http"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+// NoRule is the rule label value of requests not matching any policy rule.
+const NoRule = "none"
+
+// Labeler adds a label to the metrics of a cache, computed from the request
+// each operation is made for. The request is read from the operation context,
+// see pagecache.WithRequest; operations without one get an empty label value.
+type Labeler struct {
+	// Func returns the label value for the given request, using the policy
+	// of the wrapped cache.
+	Func func(policy *pagecache.Policy, req *http.Request) string
+
+	// Name is the name of the label.
+	Name string
+}
+
+// ByHost labels metrics by the host of the request URL.
+var ByHost = &Labeler{
+	Name: "host",
+	Func: func(_ *pagecache.Policy, req *http.Request) string {
+		if req.URL == nil {
+			return ""
+		}
+
+		return req.URL.Host
+	},
+}
+
+// ByRule labels metrics by the URL or pattern of the first policy rule
+// matching the request, or NoRule if there is none.
+var ByRule = &Labeler{
+	Name: "rule",
+	Func: func(policy *pagecache.Policy, req *http.Request) string {
+		rule := policy.MatchRule(req)
+		if rule == nil {
+			return NoRule
+		}
+
+		if rule.URL != "" {
+			return rule.URL
+		}
+
+		return rule.Pattern
+	},
+}
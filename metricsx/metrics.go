@@ -0,0 +1,219 @@
+package metricsx
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+// Operation names used to label operation metrics.
+const (
+	OperationGet           = "get"
+	OperationSet           = "set"
+	OperationDelete        = "delete"
+	OperationPurge         = "purge"
+	OperationPurgeTags     = "purge_tags"
+	OperationPurgeURL      = "purge_url"
+	OperationPurgeMatching = "purge_matching"
+)
+
+// UnknownErrorType is the error type label of errors that are not a
+// *pagecache.Error.
+const UnknownErrorType = "unknown"
+
+// DefaultBuckets are the default upper bounds of the latency histogram
+// buckets, in seconds.
+var DefaultBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// Sample holds the metrics of a cache for a single label value.
+type Sample struct {
+	// Operations holds the metrics of each operation, by operation name.
+	Operations map[string]OperationSample `json:"operations"`
+
+	// Label is the value of the optional label, or empty if the cache is not
+	// labelled.
+	Label string `json:"label,omitempty"`
+
+	// Hits is the number of lookups that found an entry.
+	Hits uint64 `json:"hits"`
+
+	// Misses is the number of lookups that did not find an entry.
+	Misses uint64 `json:"misses"`
+
+	// Stale is the number of lookups that found an expired entry, which are
+	// counted as misses too.
+	Stale uint64 `json:"stale"`
+
+	// Revalidations is the number of entries revalidated with the origin, as
+	// reported by pagecache.Transport.
+	Revalidations uint64 `json:"revalidations"`
+}
+
+// OperationSample holds the metrics of a single cache operation.
+type OperationSample struct {
+	// Errors is the number of failed operations, by error type.
+	Errors map[string]uint64 `json:"errors,omitempty"`
+
+	// Buckets is the cumulative number of operations that took at most the
+	// duration of the matching bucket upper bound.
+	Buckets []uint64 `json:"buckets"`
+
+	// Count is the number of operations.
+	Count uint64 `json:"count"`
+
+	// Sum is the total duration of the operations, in seconds.
+	Sum float64 `json:"sum"`
+}
+
+// metrics holds the metrics of a cache, by label value.
+type metrics struct {
+	series  map[string]*Sample
+	buckets []float64
+	mu      sync.Mutex
+}
+
+// newMetrics creates a new metrics using the given histogram buckets.
+func newMetrics(buckets []float64) *metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+
+	return &metrics{
+		series:  make(map[string]*Sample),
+		buckets: sorted,
+	}
+}
+
+// sample returns the series of the given label value, creating it if needed.
+// It must be called with the lock held.
+func (m *metrics) sample(label string) *Sample {
+	s, ok := m.series[label]
+	if !ok {
+		s = &Sample{
+			Label:      label,
+			Operations: make(map[string]OperationSample),
+		}
+
+		m.series[label] = s
+	}
+
+	return s
+}
+
+// observe records an operation that took the given duration and returned the
+// given error.
+func (m *metrics) observe(label, operation string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		s       = m.sample(label)
+		op      = s.Operations[operation]
+		seconds = duration.Seconds()
+	)
+
+	if op.Buckets == nil {
+		op.Buckets = make([]uint64, len(m.buckets))
+	}
+
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			op.Buckets[i]++
+		}
+	}
+
+	op.Count++
+	op.Sum += seconds
+
+	if err != nil {
+		if op.Errors == nil {
+			op.Errors = make(map[string]uint64)
+		}
+
+		op.Errors[errorType(err)]++
+	}
+
+	s.Operations[operation] = op
+}
+
+// lookup records the outcome of a lookup, which found an expired entry if
+// stale is true.
+func (m *metrics) lookup(label string, hit, stale bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.sample(label)
+
+	switch {
+	case hit:
+		s.Hits++
+	case stale:
+		s.Misses++
+		s.Stale++
+	default:
+		s.Misses++
+	}
+}
+
+// revalidation records an entry being revalidated.
+func (m *metrics) revalidation(label string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sample(label).Revalidations++
+}
+
+// samples returns a copy of the series, sorted by label value.
+func (m *metrics) samples() []Sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	samples := make([]Sample, 0, len(m.series))
+
+	for _, s := range m.series {
+		sample := *s
+		sample.Operations = make(map[string]OperationSample, len(s.Operations))
+
+		for name, op := range s.Operations {
+			copied := op
+			copied.Buckets = append([]uint64(nil), op.Buckets...)
+
+			if op.Errors != nil {
+				copied.Errors = make(map[string]uint64, len(op.Errors))
+
+				for errType, n := range op.Errors {
+					copied.Errors[errType] = n
+				}
+			}
+
+			sample.Operations[name] = copied
+		}
+
+		samples = append(samples, sample)
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Label < samples[j].Label
+	})
+
+	return samples
+}
+
+// errorType returns the error type label of the given error.
+func errorType(err error) string {
+	var cacheErr *pagecache.Error
+	if errors.As(err, &cacheErr) {
+		return cacheErr.Type().String()
+	}
+
+	return UnknownErrorType
+}
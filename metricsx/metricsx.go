@@ -0,0 +1,8 @@
+// Package metricsx wraps any [pagecache.Cache] to collect usage metrics, and
+// exposes them in the [Prometheus text format] and through [expvar], without
+// depending on a metrics client library.
+//
+// [pagecache.Cache]: https://godocs.io/git.sr.ht/~jamesponddotco/pagecache-go#Cache
+// [Prometheus text format]: https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+// [expvar]: https://pkg.go.dev/expvar
+package metricsx
@@ -0,0 +1,380 @@
+package metricsx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"git.sr.ht/~jamesponddotco/xstd-go/xerrors"
+)
+
+const (
+	// ErrDuplicateName is returned when registering a cache under a name
+	// already in use.
+	ErrDuplicateName xerrors.Error = "a cache with this name is already registered"
+
+	// ErrNameEmpty is returned when registering a cache, or publishing a
+	// registry, without a name.
+	ErrNameEmpty xerrors.Error = "cache name must not be empty"
+
+	// ErrPublished is returned when publishing a registry under a name
+	// already used by another expvar variable.
+	ErrPublished xerrors.Error = "an expvar variable with this name is already published"
+)
+
+// Prefix is the prefix of the names of the exposed Prometheus metrics.
+const Prefix = "pagecache_"
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry groups the metrics of several caches and exposes them as an
+// http.Handler serving the Prometheus text format, and as an expvar.Var
+// serving JSON.
+type Registry struct {
+	caches map[string]*Cache
+	mu     sync.RWMutex
+}
+
+// Compile-time checks to ensure Registry implements the http.Handler and
+// expvar.Var interfaces.
+var (
+	_ http.Handler = (*Registry)(nil)
+	_ expvar.Var   = (*Registry)(nil)
+)
+
+// _publishMu serializes calls to Publish, since expvar.Publish panics when a
+// name is reused.
+var _publishMu sync.Mutex //nolint:gochecknoglobals // guards the global expvar namespace
+
+// NewRegistry creates a new Registry holding the given caches. It returns an
+// error if two caches share a name or a cache has no name.
+func NewRegistry(caches ...*Cache) (*Registry, error) {
+	r := &Registry{
+		caches: make(map[string]*Cache, len(caches)),
+	}
+
+	for _, cache := range caches {
+		if err := r.Register(cache); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Publish exposes the registry through expvar under the given name, so its
+// metrics are served as JSON by the expvar handler. It returns an error
+// instead of panicking like expvar.Publish if the name is already in use.
+func (r *Registry) Publish(name string) error {
+	if name == "" {
+		return ErrNameEmpty
+	}
+
+	_publishMu.Lock()
+	defer _publishMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("%w: %q", ErrPublished, name)
+	}
+
+	expvar.Publish(name, r)
+
+	return nil
+}
+
+// Register adds the given cache to the registry.
+func (r *Registry) Register(cache *Cache) error {
+	if cache.Name() == "" {
+		return ErrNameEmpty
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.caches[cache.Name()]; ok {
+		return fmt.Errorf("%w: %q", ErrDuplicateName, cache.Name())
+	}
+
+	r.caches[cache.Name()] = cache
+
+	return nil
+}
+
+// Unregister removes the cache with the given name from the registry.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.caches, name)
+}
+
+// ServeHTTP implements the http.Handler interface, writing the metrics of the
+// registered caches in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+
+	if _, err := r.WriteTo(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+
+	_, _ = buf.WriteTo(w) //nolint:errcheck // nothing left to do if the client went away
+}
+
+// String implements the expvar.Var interface, returning the metrics of the
+// registered caches as a JSON object keyed by cache name.
+func (r *Registry) String() string {
+	samples := make(map[string][]Sample)
+
+	for _, cache := range r.sorted() {
+		samples[cache.Name()] = cache.Samples()
+	}
+
+	data, err := json.Marshal(samples)
+	if err != nil {
+		return "{}"
+	}
+
+	return string(data)
+}
+
+// WriteTo writes the metrics of the registered caches to w in the Prometheus
+// text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	var (
+		caches  = r.sorted()
+		samples = make([][]Sample, len(caches))
+	)
+
+	for i, cache := range caches {
+		samples[i] = cache.Samples()
+	}
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	cw.family("operations_total", "counter", "Number of cache operations.")
+
+	r.each(caches, samples, func(_ *Cache, base labels, s Sample) {
+		for _, name := range operationNames(s) {
+			cw.sample("operations_total", base.with("operation", name), uint64Value(s.Operations[name].Count))
+		}
+	})
+
+	cw.family("hits_total", "counter", "Number of cache lookups that found an entry.")
+
+	r.each(caches, samples, func(_ *Cache, base labels, s Sample) {
+		cw.sample("hits_total", base, uint64Value(s.Hits))
+	})
+
+	cw.family("misses_total", "counter", "Number of cache lookups that did not find an entry.")
+
+	r.each(caches, samples, func(_ *Cache, base labels, s Sample) {
+		cw.sample("misses_total", base, uint64Value(s.Misses))
+	})
+
+	cw.family("stale_total", "counter", "Number of cache lookups that found an expired entry.")
+
+	r.each(caches, samples, func(_ *Cache, base labels, s Sample) {
+		cw.sample("stale_total", base, uint64Value(s.Stale))
+	})
+
+	cw.family("revalidations_total", "counter", "Number of cache entries revalidated with the origin.")
+
+	r.each(caches, samples, func(_ *Cache, base labels, s Sample) {
+		cw.sample("revalidations_total", base, uint64Value(s.Revalidations))
+	})
+
+	cw.family("errors_total", "counter", "Number of failed cache operations, by error type.")
+
+	r.each(caches, samples, func(_ *Cache, base labels, s Sample) {
+		for _, name := range operationNames(s) {
+			errs := s.Operations[name].Errors
+
+			types := make([]string, 0, len(errs))
+			for errType := range errs {
+				types = append(types, errType)
+			}
+
+			sort.Strings(types)
+
+			for _, errType := range types {
+				cw.sample("errors_total", base.with("operation", name).with("type", errType), uint64Value(errs[errType]))
+			}
+		}
+	})
+
+	cw.family("operation_duration_seconds", "histogram", "Latency of cache operations.")
+
+	r.each(caches, samples, func(cache *Cache, base labels, s Sample) {
+		for _, name := range operationNames(s) {
+			var (
+				op     = s.Operations[name]
+				opBase = base.with("operation", name)
+			)
+
+			for i, bound := range cache.metrics.buckets {
+				cw.sample("operation_duration_seconds_bucket", opBase.with("le", floatValue(bound)), uint64Value(op.Buckets[i]))
+			}
+
+			cw.sample("operation_duration_seconds_bucket", opBase.with("le", "+Inf"), uint64Value(op.Count))
+			cw.sample("operation_duration_seconds_sum", opBase, floatValue(op.Sum))
+			cw.sample("operation_duration_seconds_count", opBase, uint64Value(op.Count))
+		}
+	})
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, fmt.Errorf("%w", err)
+	}
+
+	return cw.n, nil
+}
+
+// each calls fn for each sample of each cache, with the labels identifying
+// the sample.
+func (*Registry) each(caches []*Cache, samples [][]Sample, fn func(cache *Cache, base labels, s Sample)) {
+	for i, cache := range caches {
+		for _, s := range samples[i] {
+			fn(cache, cache.labels(s), s)
+		}
+	}
+}
+
+// sorted returns the registered caches, sorted by name.
+func (r *Registry) sorted() []*Cache {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	caches := make([]*Cache, 0, len(r.caches))
+	for _, cache := range r.caches {
+		caches = append(caches, cache)
+	}
+
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].Name() < caches[j].Name()
+	})
+
+	return caches
+}
+
+// labels returns the labels identifying the given sample of the cache.
+func (c *Cache) labels(s Sample) labels {
+	base := labels{{"cache", c.name}}
+
+	if c.labeler != nil {
+		base = base.with(c.labeler.Name, s.Label)
+	}
+
+	return base
+}
+
+// operationNames returns the names of the operations of the given sample,
+// sorted.
+func operationNames(s Sample) []string {
+	names := make([]string, 0, len(s.Operations))
+	for name := range s.Operations {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// labels is an ordered list of label name and value pairs.
+type labels [][2]string
+
+// with returns a copy of the labels with the given label appended.
+func (l labels) with(name, value string) labels {
+	copied := make(labels, len(l), len(l)+1)
+	copy(copied, l)
+
+	return append(copied, [2]string{name, value})
+}
+
+// String returns the labels in the Prometheus text format.
+func (l labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+
+	b.WriteByte('{')
+
+	for i, label := range l {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(label[0])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(label[1]))
+		b.WriteByte('"')
+	}
+
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// labelEscaper escapes label values for the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// uint64Value formats an integer sample value.
+func uint64Value(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+// floatValue formats a floating-point sample value.
+func floatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter writes metrics, counting the bytes written and keeping the
+// first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	err error
+	n   int64
+}
+
+// family writes the HELP and TYPE lines of a metric family.
+func (cw *countingWriter) family(name, kind, help string) {
+	cw.printf("# HELP %s%s %s\n# TYPE %s%s %s\n", Prefix, name, help, Prefix, name, kind)
+}
+
+// sample writes a single sample line.
+func (cw *countingWriter) sample(name string, l labels, value string) {
+	cw.printf("%s%s%s %s\n", Prefix, name, l, value)
+}
+
+// printf writes formatted output unless a previous write failed.
+func (cw *countingWriter) printf(format string, args ...any) {
+	if cw.err != nil {
+		return
+	}
+
+	n, err := fmt.Fprintf(cw.w, format, args...)
+
+	cw.n += int64(n)
+
+	if err != nil {
+		cw.err = fmt.Errorf("%w", err)
+	}
+}
@@ -0,0 +1,228 @@
+package metricsx_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+	"git.sr.ht/~jamesponddotco/pagecache-go/metricsx"
+)
+
+func TestRegistry_Register(t *testing.T) {
+	t.Parallel()
+
+	registry, err := metricsx.NewRegistry(metricsx.NewCache("a", memorycachex.NewCache(nil, 0), nil))
+	if err != nil {
+		t.Fatalf("NewRegistry() unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		cache   *metricsx.Cache
+		wantErr error
+	}{
+		{
+			name:  "New name",
+			cache: metricsx.NewCache("b", memorycachex.NewCache(nil, 0), nil),
+		},
+		{
+			name:    "Duplicate name",
+			cache:   metricsx.NewCache("a", memorycachex.NewCache(nil, 0), nil),
+			wantErr: metricsx.ErrDuplicateName,
+		},
+		{
+			name:    "Empty name",
+			cache:   metricsx.NewCache("", memorycachex.NewCache(nil, 0), nil),
+			wantErr: metricsx.ErrNameEmpty,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := registry.Register(tt.cache); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Register() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewRegistry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		caches  []*metricsx.Cache
+		wantErr error
+	}{
+		{
+			name: "Distinct names",
+			caches: []*metricsx.Cache{
+				metricsx.NewCache("a", memorycachex.NewCache(nil, 0), nil),
+				metricsx.NewCache("b", memorycachex.NewCache(nil, 0), nil),
+			},
+		},
+		{
+			name: "Duplicate name",
+			caches: []*metricsx.Cache{
+				metricsx.NewCache("a", memorycachex.NewCache(nil, 0), nil),
+				metricsx.NewCache("a", memorycachex.NewCache(nil, 0), nil),
+			},
+			wantErr: metricsx.ErrDuplicateName,
+		},
+		{
+			name: "Empty name",
+			caches: []*metricsx.Cache{
+				metricsx.NewCache("", memorycachex.NewCache(nil, 0), nil),
+			},
+			wantErr: metricsx.ErrNameEmpty,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			registry, err := metricsx.NewRegistry(tt.caches...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewRegistry() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if (registry == nil) != (tt.wantErr != nil) {
+				t.Errorf("NewRegistry() = %v, want a registry only without error", registry)
+			}
+		})
+	}
+}
+
+func TestRegistry_Publish(t *testing.T) {
+	t.Parallel()
+
+	cache := metricsx.NewCache("test", memorycachex.NewCache(nil, 0), nil)
+
+	registry, err := metricsx.NewRegistry(cache)
+	if err != nil {
+		t.Fatalf("NewRegistry() unexpected error: %v", err)
+	}
+
+	// expvar names can't be unpublished, so each run uses its own.
+	name := "metricsx_test_publish_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	if err := registry.Publish(name); err != nil {
+		t.Fatalf("Publish() unexpected error: %v", err)
+	}
+
+	if got := expvar.Get(name); got != registry {
+		t.Errorf("expvar.Get() = %v, want the registry", got)
+	}
+
+	if err := registry.Publish(name); !errors.Is(err, metricsx.ErrPublished) {
+		t.Errorf("Publish() with a used name error = %v, want %v", err, metricsx.ErrPublished)
+	}
+
+	if err := registry.Publish(""); !errors.Is(err, metricsx.ErrNameEmpty) {
+		t.Errorf("Publish() with an empty name error = %v, want %v", err, metricsx.ErrNameEmpty)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	cache := metricsx.NewCache(`we"ird`, memorycachex.NewCache(nil, 0), &metricsx.Options{
+		Labeler: metricsx.ByHost,
+		Buckets: []float64{1, 0.5},
+	})
+
+	ctx := requestContext(t, "http://example.com/page")
+
+	if _, err := cache.Get(ctx, "key"); err == nil {
+		t.Fatal("Get() expected error, got nil")
+	}
+
+	if err := cache.Delete(context.Background(), "key"); err == nil {
+		t.Fatal("Delete() expected error, got nil")
+	}
+
+	rec := httptest.NewRecorder()
+
+	registry, err := metricsx.NewRegistry(cache)
+	if err != nil {
+		t.Fatalf("NewRegistry() unexpected error: %v", err)
+	}
+
+	registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+
+	resp := rec.Result()
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != metricsx.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, metricsx.ContentType)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"# TYPE pagecache_operations_total counter\n",
+		`pagecache_operations_total{cache="we\"ird",host="example.com",operation="get"} 1` + "\n",
+		`pagecache_misses_total{cache="we\"ird",host="example.com"} 1` + "\n",
+		"# TYPE pagecache_stale_total counter\n",
+		`pagecache_stale_total{cache="we\"ird",host="example.com"} 0` + "\n",
+		"# TYPE pagecache_revalidations_total counter\n",
+		`pagecache_revalidations_total{cache="we\"ird",host="example.com"} 0` + "\n",
+		`pagecache_errors_total{cache="we\"ird",host="",operation="delete",type="not found"} 1` + "\n",
+		"# TYPE pagecache_operation_duration_seconds histogram\n",
+		`pagecache_operation_duration_seconds_bucket{cache="we\"ird",host="example.com",operation="get",le="0.5"} 1` + "\n",
+		`pagecache_operation_duration_seconds_bucket{cache="we\"ird",host="example.com",operation="get",le="1"} 1` + "\n",
+		`pagecache_operation_duration_seconds_bucket{cache="we\"ird",host="example.com",operation="get",le="+Inf"} 1` + "\n",
+		`pagecache_operation_duration_seconds_count{cache="we\"ird",host="example.com",operation="get"} 1` + "\n",
+	}
+
+	for _, line := range want {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("ServeHTTP() body missing %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestRegistry_String(t *testing.T) {
+	t.Parallel()
+
+	cache := metricsx.NewCache("test", memorycachex.NewCache(nil, 0), nil)
+
+	if _, err := cache.Get(context.Background(), "key"); err == nil {
+		t.Fatal("Get() expected error, got nil")
+	}
+
+	registry, err := metricsx.NewRegistry(cache)
+	if err != nil {
+		t.Fatalf("NewRegistry() unexpected error: %v", err)
+	}
+
+	var got map[string][]metricsx.Sample
+
+	if err := json.Unmarshal([]byte(registry.String()), &got); err != nil {
+		t.Fatalf("String() returned invalid JSON: %v", err)
+	}
+
+	samples := got["test"]
+	if len(samples) != 1 || samples[0].Misses != 1 || samples[0].Operations[metricsx.OperationGet].Count != 1 {
+		t.Errorf("String() = %+v, want one miss", got)
+	}
+}
@@ -0,0 +1,154 @@
+package pagecache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Middleware is an http.Handler serving responses from a Cache when possible,
+// and storing cacheable responses of the handler it wraps otherwise.
+//
+// Requests are handled by a Transport whose underlying RoundTripper calls the
+// wrapped handler, so the middleware behaves exactly like the caching
+// transport, including cache keys, invalidation, events and Cache-Status
+// headers. Responses of the wrapped handler are buffered in memory before
+// being written to the client.
+type Middleware struct {
+	// Transport handles the caching logic. Its underlying RoundTripper calls
+	// the wrapped handler, and its other fields may be configured freely.
+	Transport *Transport
+}
+
+// Compile-time check to ensure Middleware implements the http.Handler
+// interface.
+var _ http.Handler = (*Middleware)(nil)
+
+// NewMiddleware creates a new Middleware that caches responses from the given
+// handler, which must not be nil, in the given Cache.
+func NewMiddleware(cache Cache, next http.Handler) *Middleware {
+	return &Middleware{
+		Transport: NewTransport(cache, &handlerTransport{handler: next}),
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Server requests only carry the path and query in their URL, while cache
+	// keys need the absolute URL.
+	req := r.Clone(r.Context())
+	req.URL.Host = r.Host
+	req.URL.Scheme = "http"
+
+	if r.TLS != nil {
+		req.URL.Scheme = "https"
+	}
+
+	resp, err := m.Transport.RoundTrip(req)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+
+		return
+	}
+	defer resp.Body.Close()
+
+	header := w.Header()
+
+	for name, values := range resp.Header {
+		header[name] = values
+	}
+
+	w.WriteHeader(resp.StatusCode)
+
+	_, _ = io.Copy(w, resp.Body) //nolint:errcheck // nothing left to do if the client went away
+}
+
+// handlerTransport is an http.RoundTripper calling an http.Handler.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (ht *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Give the handler the request URL in its usual server-side form.
+	inner := req.Clone(req.Context())
+	inner.URL.Scheme = ""
+	inner.URL.Host = ""
+
+	rec := &responseRecorder{
+		header: make(http.Header),
+	}
+
+	ht.handler.ServeHTTP(rec, inner)
+
+	// Handlers that never write the header respond with 200 OK.
+	rec.WriteHeader(http.StatusOK)
+
+	if rec.written.Get("Content-Type") == "" && rec.body.Len() > 0 {
+		rec.written.Set("Content-Type", http.DetectContentType(rec.body.Bytes()))
+	}
+
+	resp := &http.Response{
+		Status:        strconv.Itoa(rec.status) + " " + http.StatusText(rec.status),
+		StatusCode:    rec.status,
+		Proto:         req.Proto,
+		ProtoMajor:    req.ProtoMajor,
+		ProtoMinor:    req.ProtoMinor,
+		Header:        rec.written,
+		Body:          io.NopCloser(&rec.body),
+		ContentLength: int64(rec.body.Len()),
+		Request:       req,
+	}
+
+	if resp.Header.Get("Content-Length") == "" {
+		resp.Header.Set("Content-Length", strconv.Itoa(rec.body.Len()))
+	}
+
+	return resp, nil
+}
+
+// responseRecorder is an http.ResponseWriter buffering the response written
+// to it.
+type responseRecorder struct {
+	// header is the header map returned by Header.
+	header http.Header
+
+	// written is a snapshot of header taken by WriteHeader, since later
+	// changes to the header map must not affect the response, just like with
+	// a real connection.
+	written http.Header
+
+	body   bytes.Buffer
+	status int
+}
+
+// Header implements the http.ResponseWriter interface.
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+// Write implements the http.ResponseWriter interface.
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if rr.status == 0 {
+		rr.WriteHeader(http.StatusOK)
+	}
+
+	n, err := rr.body.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("%w", err)
+	}
+
+	return n, nil
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status != 0 {
+		return
+	}
+
+	rr.status = status
+	rr.written = rr.header.Clone()
+}
@@ -0,0 +1,101 @@
+package pagecache_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+func TestMiddleware_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+
+		if r.URL.Host != "" || r.URL.Scheme != "" {
+			t.Errorf("handler got absolute URL %q", r.URL)
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Status", "App; fwd=uri-miss")
+		w.WriteHeader(http.StatusOK)
+
+		// Header changes after WriteHeader are ignored.
+		w.Header().Set("X-Late", "1")
+
+		fmt.Fprintf(w, "response %d for %s", n, r.Host)
+	})
+
+	middleware := pagecache.NewMiddleware(memorycachex.NewCache(nil, 0), handler)
+
+	tests := []struct {
+		name       string
+		host       string
+		wantBody   string
+		wantStatus string
+	}{
+		{
+			name:       "Miss",
+			host:       "a.example.com",
+			wantBody:   "response 1 for a.example.com",
+			wantStatus: "httpx; fwd=uri-miss; fwd-status=200",
+		},
+		{
+			name:       "Hit",
+			host:       "a.example.com",
+			wantBody:   "response 1 for a.example.com",
+			wantStatus: "httpx; hit",
+		},
+		{
+			name:       "Other host",
+			host:       "b.example.com",
+			wantBody:   "response 2 for b.example.com",
+			wantStatus: "httpx; fwd=uri-miss; fwd-status=200",
+		},
+	}
+
+	// The cases don't run in parallel, since each one depends on the cache
+	// state left by the previous ones.
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/page", http.NoBody)
+			req.Host = tt.host
+
+			rec := httptest.NewRecorder()
+
+			middleware.ServeHTTP(rec, req)
+
+			resp := rec.Result()
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+
+			if resp.Header.Get("X-Late") != "" {
+				t.Error("header set after WriteHeader was sent")
+			}
+
+			got := resp.Header.Values("Cache-Status")
+			if len(got) != 2 || got[0] != "App; fwd=uri-miss" || !strings.HasPrefix(got[1], tt.wantStatus) {
+				t.Errorf("Cache-Status = %q, want [%q %q...]", got, "App; fwd=uri-miss", tt.wantStatus)
+			}
+		})
+	}
+}
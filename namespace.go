@@ -0,0 +1,354 @@
+package pagecache
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCleanupBatch is the default number of stale entries a Namespace
+// deletes from the underlying cache per operation.
+const DefaultCleanupBatch int = 16
+
+// Namespace is a Cache that wraps another Cache and prefixes every key with a
+// namespace name and a generation number, allowing every entry in the
+// namespace to be invalidated at once, in constant time, by incrementing the
+// generation.
+//
+// Entries from previous generations are never served again, and are deleted
+// from the underlying cache lazily, a few at a time on each operation, or all
+// at once by calling Cleanup. They are found through an index of the keys the
+// namespace stored in each generation, pruning the keys of expired entries as
+// it goes, so operations don't depend on the size of the underlying cache. If
+// the underlying cache implements Lister, Cleanup also scans its keys, which
+// finds entries left by previous processes as well.
+//
+// The generation only lives in memory. Processes sharing the underlying cache,
+// or restarting, must persist the generation returned by Invalidate and supply
+// it back through NewNamespace or SetGeneration, or they will serve entries
+// invalidated elsewhere.
+type Namespace struct {
+	// cache is the underlying cache.
+	cache Cache
+
+	// index maps each generation to the prefixed keys stored in it and their
+	// expiration time.
+	index map[uint64]map[string]time.Time
+
+	// name is the namespace name.
+	name string
+
+	// generation is the current generation number.
+	generation atomic.Uint64
+
+	// changes counts the events that may leave entries from previous
+	// generations in the underlying cache, and cleaned is its value when the
+	// last complete cleanup started. Operations skip cleaning up while they
+	// are equal.
+	changes atomic.Uint64
+	cleaned atomic.Uint64
+
+	// batch is the number of stale entries deleted per operation.
+	batch int
+
+	// cleaning serializes cleanups.
+	cleaning sync.Mutex
+
+	// mu protects index.
+	mu sync.Mutex
+}
+
+// Compile-time check to ensure Namespace implements the Cache interface.
+var _ Cache = (*Namespace)(nil)
+
+// NewNamespace creates a new Namespace with the given name and initial
+// generation wrapping the given cache. The initial generation can be used as
+// a schema version, bumped whenever a deploy changes how pages are rendered,
+// or be one persisted by a previous process.
+//
+// The name is escaped in the keys of the underlying cache, so namespaces never
+// see each other's keys, whatever their names.
+func NewNamespace(cache Cache, name string, generation uint64) *Namespace {
+	ns := &Namespace{
+		cache: cache,
+		index: make(map[uint64]map[string]time.Time),
+		name:  name,
+		batch: DefaultCleanupBatch,
+	}
+
+	ns.generation.Store(generation)
+
+	return ns
+}
+
+// Name returns the namespace name.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Generation returns the current generation number.
+func (ns *Namespace) Generation() uint64 {
+	return ns.generation.Load()
+}
+
+// SetGeneration sets the current generation number, such as one persisted by
+// a previous process or received from another process sharing the underlying
+// cache. Entries from other generations are never served again, and those
+// from lower generations are cleaned up lazily.
+func (ns *Namespace) SetGeneration(generation uint64) {
+	ns.generation.Store(generation)
+	ns.changes.Add(1)
+}
+
+// Invalidate logically removes every entry in the namespace by incrementing
+// its generation, and returns the new generation number, which callers
+// sharing the underlying cache with other processes should persist.
+func (ns *Namespace) Invalidate() uint64 {
+	generation := ns.generation.Add(1)
+	ns.changes.Add(1)
+
+	return generation
+}
+
+// Get retrieves an *http.Response from the namespace.
+func (ns *Namespace) Get(ctx context.Context, key string) (*http.Response, error) {
+	ns.cleanup(ctx, ns.batch)
+
+	return ns.cache.Get(ctx, ns.key(ns.Generation(), key)) //nolint:wrapcheck // errors from the underlying cache are returned as is
+}
+
+// Set stores an *http.Response in the namespace.
+func (ns *Namespace) Set(ctx context.Context, key string, resp *http.Response, duration time.Duration) error {
+	ns.cleanup(ctx, ns.batch)
+
+	var (
+		generation = ns.Generation()
+		prefixed   = ns.key(generation, key)
+	)
+
+	if err := ns.cache.Set(ctx, prefixed, resp, duration); err != nil {
+		return err //nolint:wrapcheck // errors from the underlying cache are returned as is
+	}
+
+	ns.mu.Lock()
+
+	keys, ok := ns.index[generation]
+	if !ok {
+		keys = make(map[string]time.Time)
+		ns.index[generation] = keys
+	}
+
+	keys[prefixed] = time.Now().Add(duration)
+
+	ns.mu.Unlock()
+
+	if generation != ns.Generation() {
+		// The namespace was invalidated while storing the entry.
+		ns.changes.Add(1)
+	}
+
+	return nil
+}
+
+// Delete removes the entry associated with the given key from the namespace.
+func (ns *Namespace) Delete(ctx context.Context, key string) error {
+	var (
+		generation = ns.Generation()
+		prefixed   = ns.key(generation, key)
+	)
+
+	ns.mu.Lock()
+	delete(ns.index[generation], prefixed)
+	ns.mu.Unlock()
+
+	return ns.cache.Delete(ctx, prefixed) //nolint:wrapcheck // errors from the underlying cache are returned as is
+}
+
+// Policy returns the cache policy of the underlying cache.
+func (ns *Namespace) Policy() *Policy {
+	return ns.cache.Policy()
+}
+
+// Purge invalidates every entry in the namespace. Other namespaces sharing the
+// underlying cache are not affected.
+func (ns *Namespace) Purge(_ context.Context) error {
+	ns.Invalidate()
+
+	return nil
+}
+
+// Cleanup deletes every entry from previous generations from the underlying
+// cache. If the underlying cache implements Lister, its keys are scanned to
+// find entries the index doesn't know about, such as those left by previous
+// processes, so calling Cleanup costs time proportional to its size.
+func (ns *Namespace) Cleanup(ctx context.Context) {
+	ns.cleanup(ctx, -1)
+}
+
+// cleanup deletes up to limit entries from previous generations from the
+// underlying cache. A negative limit deletes all of them, scanning the keys of
+// the underlying cache if it implements Lister, and waits for concurrent
+// cleanups, which are skipped otherwise.
+func (ns *Namespace) cleanup(ctx context.Context, limit int) {
+	if limit < 0 {
+		ns.cleaning.Lock()
+	} else if !ns.cleaning.TryLock() {
+		return
+	}
+	defer ns.cleaning.Unlock()
+
+	var (
+		changes    = ns.changes.Load()
+		clean      = ns.isClean()
+		generation = ns.Generation()
+		keys       []string
+	)
+
+	ns.mu.Lock()
+
+	if !clean {
+		keys = ns.drain(generation, limit)
+	}
+
+	// Entries evicted by the underlying cache can't be noticed, but expired
+	// ones can, so the index doesn't grow without bound.
+	ns.prune(generation, limit)
+
+	ns.mu.Unlock()
+
+	ns.deleteKeys(ctx, keys)
+
+	if lister, ok := ns.cache.(Lister); ok && limit < 0 {
+		ns.deleteKeys(ctx, ns.scan(ctx, lister, generation))
+	}
+
+	// Finding fewer entries than asked for means none is left, unless the
+	// namespace changed in the meantime, which changes records.
+	if !clean && (limit < 0 || len(keys) < limit) {
+		ns.cleaned.Store(changes)
+	}
+}
+
+// isClean reports whether no entry from a previous generation is known to
+// remain in the underlying cache.
+func (ns *Namespace) isClean() bool {
+	return ns.cleaned.Load() == ns.changes.Load()
+}
+
+// deleteKeys deletes the entries with the given keys from the underlying cache.
+func (ns *Namespace) deleteKeys(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		// The entry may have expired or been evicted already.
+		_ = ns.cache.Delete(ctx, key) //nolint:errcheck // see above
+	}
+}
+
+// scan returns the keys of the underlying cache belonging to generations of
+// the namespace lower than the given one.
+func (ns *Namespace) scan(ctx context.Context, lister Lister, generation uint64) []string {
+	var (
+		keys   []string
+		prefix = ns.prefix()
+	)
+
+	//nolint:errcheck // a failed scan is retried on the next call to Cleanup
+	_ = lister.Range(ctx, func(info EntryInfo) bool {
+		rest, ok := strings.CutPrefix(info.Key, prefix)
+		if !ok {
+			return true
+		}
+
+		number, _, ok := strings.Cut(rest, ":")
+		if !ok {
+			return true
+		}
+
+		if g, err := strconv.ParseUint(number, 10, 64); err == nil && g < generation {
+			keys = append(keys, info.Key)
+		}
+
+		return true
+	})
+
+	return keys
+}
+
+// drain removes up to limit keys of generations other than the given one from
+// the index and returns them, dropping whole generations at once when
+// possible. A negative limit returns all of them. It must be called with mu
+// held.
+func (ns *Namespace) drain(generation uint64, limit int) []string {
+	var keys []string
+
+	for g, stored := range ns.index {
+		if g == generation {
+			continue
+		}
+
+		if limit < 0 || len(stored) <= limit-len(keys) {
+			// Drop the whole generation at once.
+			for key := range stored {
+				keys = append(keys, key)
+			}
+
+			delete(ns.index, g)
+
+			continue
+		}
+
+		for key := range stored {
+			if len(keys) == limit {
+				break
+			}
+
+			keys = append(keys, key)
+			delete(stored, key)
+		}
+
+		break
+	}
+
+	return keys
+}
+
+// prune removes up to limit keys of expired entries of the given generation
+// from the index. A negative limit removes all of them. It must be called with
+// mu held.
+func (ns *Namespace) prune(generation uint64, limit int) {
+	var (
+		now     = time.Now()
+		checked int
+	)
+
+	// Map iteration starts at a random entry, so successive calls check
+	// different keys.
+	for key, expiration := range ns.index[generation] {
+		if limit >= 0 && checked == limit {
+			break
+		}
+
+		checked++
+
+		if now.After(expiration) {
+			delete(ns.index[generation], key)
+		}
+	}
+}
+
+// prefix returns the prefix shared by the keys of every generation of the
+// namespace. The name is escaped so it never contains a colon, and the prefix
+// of a namespace can't start with the prefix of another one.
+func (ns *Namespace) prefix() string {
+	return "ns:" + url.QueryEscape(ns.name) + ":gen:"
+}
+
+// key returns the given key prefixed with the namespace name and the given
+// generation.
+func (ns *Namespace) key(generation uint64, key string) string {
+	return ns.prefix() + strconv.FormatUint(generation, 10) + ":" + key
+}
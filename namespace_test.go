@@ -0,0 +1,309 @@
+package pagecache_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+func TestNamespace(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		underlying = memorycachex.NewCache(nil, 0)
+		blog       = pagecache.NewNamespace(underlying, "blog", 1)
+		shop       = pagecache.NewNamespace(underlying, "shop", 1)
+	)
+
+	for _, ns := range []*pagecache.Namespace{blog, shop} {
+		for _, key := range []string{"a", "b", "c"} {
+			if err := ns.Set(ctx, key, newTestResponse(t), time.Minute); err != nil {
+				t.Fatalf("Set() unexpected error: %v", err)
+			}
+		}
+	}
+
+	if _, err := blog.Get(ctx, "a"); err != nil {
+		t.Fatalf("Get() unexpected error: %v", err)
+	}
+
+	if _, err := underlying.Get(ctx, "a"); !errors.Is(err, pagecache.ErrCacheMiss) {
+		t.Errorf("underlying Get() with unprefixed key = %v, want %v", err, pagecache.ErrCacheMiss)
+	}
+
+	if got := blog.Invalidate(); got != 2 {
+		t.Errorf("Invalidate() = %d, want 2", got)
+	}
+
+	if _, err := blog.Get(ctx, "b"); !errors.Is(err, pagecache.ErrCacheMiss) {
+		t.Errorf("Get() after Invalidate() = %v, want %v", err, pagecache.ErrCacheMiss)
+	}
+
+	if _, err := shop.Get(ctx, "b"); err != nil {
+		t.Errorf("Get() in another namespace after Invalidate() = %v, want nil", err)
+	}
+
+	blog.Cleanup(ctx)
+
+	old := pagecache.NewNamespace(underlying, "blog", 1)
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := old.Get(ctx, key); !errors.Is(err, pagecache.ErrCacheMiss) {
+			t.Errorf("stale entry %q still in the underlying cache after Cleanup()", key)
+		}
+	}
+
+	if err := blog.Set(ctx, "a", newTestResponse(t), time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	if _, err := blog.Get(ctx, "a"); err != nil {
+		t.Errorf("Get() in new generation = %v, want nil", err)
+	}
+}
+
+// plainCache hides every optional interface of the wrapped cache.
+type plainCache struct {
+	pagecache.Cache
+}
+
+func TestNamespace_Cleanup(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		wrap func(cache *memorycachex.MemoryCache) pagecache.Cache
+		// restart reports whether the namespace is invalidated by another
+		// instance than the one storing the entries, like after a restart.
+		restart bool
+		// wantLazy is the number of stale entries deleted by an operation.
+		wantLazy int
+	}{
+		{
+			name: "Lister",
+			wrap: func(cache *memorycachex.MemoryCache) pagecache.Cache {
+				return cache
+			},
+			wantLazy: pagecache.DefaultCleanupBatch,
+		},
+		{
+			// Only Cleanup finds the entries stored by the previous
+			// instance.
+			name: "Lister after restart",
+			wrap: func(cache *memorycachex.MemoryCache) pagecache.Cache {
+				return cache
+			},
+			restart:  true,
+			wantLazy: 0,
+		},
+		{
+			name: "Index",
+			wrap: func(cache *memorycachex.MemoryCache) pagecache.Cache {
+				return &plainCache{Cache: cache}
+			},
+			wantLazy: pagecache.DefaultCleanupBatch,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				ctx        = context.Background()
+				underlying = memorycachex.NewCache(nil, 0)
+				cache      = tt.wrap(underlying)
+				first      = pagecache.NewNamespace(cache, "blog", 1)
+				keys       = make([]string, 2*pagecache.DefaultCleanupBatch+1)
+			)
+
+			for i := range keys {
+				keys[i] = "page-" + strconv.Itoa(i)
+
+				if err := first.Set(ctx, keys[i], newTestResponse(t), time.Minute); err != nil {
+					t.Fatalf("Set() unexpected error: %v", err)
+				}
+			}
+
+			length := func() int {
+				t.Helper()
+
+				n, err := underlying.Len(ctx)
+				if err != nil {
+					t.Fatalf("Len() unexpected error: %v", err)
+				}
+
+				return n
+			}
+
+			second := first
+			if tt.restart {
+				second = pagecache.NewNamespace(cache, "blog", 1)
+			}
+
+			if got := second.Invalidate(); got != 2 {
+				t.Fatalf("Invalidate() = %d, want 2", got)
+			}
+
+			// Invalidating doesn't delete anything by itself.
+			if got := length(); got != len(keys) {
+				t.Errorf("underlying Len() after Invalidate() = %d, want %d", got, len(keys))
+			}
+
+			// Each operation deletes a batch of stale entries.
+			if err := second.Set(ctx, "new", newTestResponse(t), time.Minute); err != nil {
+				t.Fatalf("Set() unexpected error: %v", err)
+			}
+
+			if got, want := length(), len(keys)+1-tt.wantLazy; got != want {
+				t.Errorf("underlying Len() after Set() = %d, want %d", got, want)
+			}
+
+			second.Cleanup(ctx)
+
+			if got := length(); got != 1 {
+				t.Errorf("underlying Len() after Cleanup() = %d, want 1", got)
+			}
+
+			if _, err := second.Get(ctx, "new"); err != nil {
+				t.Errorf("Get() in new generation = %v, want nil", err)
+			}
+		})
+	}
+}
+
+// countingLister counts the calls to Range of the wrapped cache.
+type countingLister struct {
+	*memorycachex.MemoryCache
+	ranges atomic.Int64
+}
+
+func (cl *countingLister) Range(ctx context.Context, fn func(info pagecache.EntryInfo) bool) error {
+	cl.ranges.Add(1)
+
+	return cl.MemoryCache.Range(ctx, fn)
+}
+
+func TestNamespace_OperationsDontScan(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx   = context.Background()
+		cache = &countingLister{MemoryCache: memorycachex.NewCache(nil, 0)}
+		ns    = pagecache.NewNamespace(cache, "blog", 1)
+	)
+
+	for i := 0; i < 3; i++ {
+		if err := ns.Set(ctx, "page", newTestResponse(t), time.Minute); err != nil {
+			t.Fatalf("Set() unexpected error: %v", err)
+		}
+
+		ns.Invalidate()
+
+		if _, err := ns.Get(ctx, "page"); !errors.Is(err, pagecache.ErrCacheMiss) {
+			t.Fatalf("Get() after Invalidate() = %v, want %v", err, pagecache.ErrCacheMiss)
+		}
+	}
+
+	if got := cache.ranges.Load(); got != 0 {
+		t.Errorf("Range() called %d times by Get() and Set(), want 0", got)
+	}
+
+	ns.Cleanup(ctx)
+
+	if got := cache.ranges.Load(); got != 1 {
+		t.Errorf("Range() called %d times by Cleanup(), want 1", got)
+	}
+}
+
+func TestNamespace_Cleanup_OtherNamespaces(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		underlying = memorycachex.NewCache(nil, 0)
+		blog       = pagecache.NewNamespace(underlying, "blog", 3)
+		tricky     = pagecache.NewNamespace(underlying, "blog:gen:1", 5)
+	)
+
+	if err := tricky.Set(ctx, "page", newTestResponse(t), time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	blog.Cleanup(ctx)
+
+	if _, err := tricky.Get(ctx, "page"); err != nil {
+		t.Errorf("Get() after Cleanup() of another namespace = %v, want nil", err)
+	}
+}
+
+func TestNamespace_SetGeneration(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx        = context.Background()
+		underlying = memorycachex.NewCache(nil, 0)
+		first      = pagecache.NewNamespace(underlying, "blog", 1)
+		second     = pagecache.NewNamespace(underlying, "blog", 1)
+	)
+
+	if err := second.Set(ctx, "a", newTestResponse(t), time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	generation := first.Invalidate()
+
+	if _, err := second.Get(ctx, "a"); err != nil {
+		t.Errorf("Get() before SetGeneration() = %v, want nil", err)
+	}
+
+	second.SetGeneration(generation)
+
+	if got := second.Generation(); got != generation {
+		t.Errorf("Generation() = %d, want %d", got, generation)
+	}
+
+	if _, err := second.Get(ctx, "a"); !errors.Is(err, pagecache.ErrCacheMiss) {
+		t.Errorf("Get() after SetGeneration() = %v, want %v", err, pagecache.ErrCacheMiss)
+	}
+
+	// Entries of the newer generation survive cleanups by older instances.
+	if err := second.Set(ctx, "b", newTestResponse(t), time.Minute); err != nil {
+		t.Fatalf("Set() unexpected error: %v", err)
+	}
+
+	old := pagecache.NewNamespace(underlying, "blog", 1)
+	old.Cleanup(ctx)
+
+	if _, err := second.Get(ctx, "b"); err != nil {
+		t.Errorf("Get() after older Cleanup() = %v, want nil", err)
+	}
+}
+
+func newTestResponse(t *testing.T) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(http.StatusOK)
+	rec.WriteString("OK")
+
+	resp := rec.Result()
+	resp.Request = req
+
+	return resp
+}
@@ -8,8 +8,6 @@ import (
 	"git.sr.ht/~jamesponddotco/recache-go"
 	"git.sr.ht/~jamesponddotco/recache-go/lrure"
 	"git.sr.ht/~jamesponddotco/xstd-go/xerrors"
-	"git.sr.ht/~jamesponddotco/xstd-go/xhash/xfnv"
-	"git.sr.ht/~jamesponddotco/xstd-go/xnet/xurl"
 )
 
 const (
@@ -30,11 +28,23 @@ var (
 	ErrCacheMiss = NewCacheError(ErrNotFound, xerrors.Error("cache miss"))
 
 	// ErrCacheExpired is returned when a cache entry is found but has expired.
-	ErrCacheExpired = NewCacheError(ErrNotFound, xerrors.Error("cache entry expired"))
+	// It is a kind of ErrCacheMiss, and matches it with errors.Is.
+	ErrCacheExpired = newKindError(ErrCacheMiss, xerrors.Error("cache entry expired"))
+
+	// ErrVaryMiss is returned when the cache holds a response for the URL of
+	// the request, but its Vary header selects it for other request header
+	// values, see VariantHeader. It is a kind of ErrCacheMiss, and matches it
+	// with errors.Is.
+	ErrVaryMiss = newKindError(ErrCacheMiss, xerrors.Error("cache variant miss"))
 
 	// ErrKeyNotFound is returned when a cache entry is not found for the given key.
 	ErrKeyNotFound = NewCacheError(ErrNotFound, xerrors.Error("key not found"))
 
+	// ErrKeyCollision is returned when a cache entry is found for the given
+	// key, but was stored for a different canonical key. It is a kind of
+	// ErrCacheMiss, and matches it with errors.Is.
+	ErrKeyCollision = newKindError(ErrCacheMiss, xerrors.Error("cache key collision"))
+
 	// ErrCacheStoreFailed is returned when storing an item in the cache fails.
 	ErrCacheStoreFailed = NewCacheError(ErrOperationFailed, xerrors.Error("failed to set cache entry"))
 
@@ -43,11 +53,21 @@ var (
 
 	// ErrCachePurgeFailed is returned when purging the entire cache fails.
 	ErrCachePurgeFailed = NewCacheError(ErrOperationFailed, xerrors.Error("failed to purge cache"))
+
+	// ErrUnsupported is returned when a cache does not support an optional
+	// operation.
+	ErrUnsupported = NewCacheError(ErrOperationFailed, xerrors.Error("operation not supported"))
 )
 
 // Key generates a cache key by concatenating information from the given
 // *http.Request, cache name, and optional extra information. It hashes the
-// result with the FNV-1 64-bit algorithm for fast hashing.
+// result with the FNV-1a 64-bit algorithm for fast hashing, see HashFNV64.
+//
+// Distinct requests may hash to the same key. Caches only detect such
+// collisions, returning ErrKeyCollision, when both the entry was stored and
+// the lookup is made with a context carrying the canonical key, see
+// WithCanonicalKey, as Transport does; otherwise the colliding entry is
+// returned.
 //
 // The generated key is of the form:
 //
@@ -56,23 +76,33 @@ var (
 // This function is not used by the package itself, but is exported for use by
 // packages implementing the Cache interface.
 func Key(name string, req *http.Request, extra ...string) string {
+	return HashFNV64(CanonicalKey(name, req, extra...))
+}
+
+// CanonicalKey returns the canonical form of the cache key generated by Key,
+// before hashing.
+//
+// If the request carries credentials, their identity, as returned by
+// Credentials, is added to the extra information as "credentials:IDENTITY".
+func CanonicalKey(name string, req *http.Request, extra ...string) string {
+	return buildKey(name, req.Method, NormalizeURL(req.URL), withCredentials(req, extra))
+}
+
+// buildKey concatenates the given information into the canonical form of a
+// cache key, before hashing.
+func buildKey(name, method, url string, extra []string) string {
 	if name == strings.TrimSpace("") {
 		name = DefaultCacheName
 	}
 
-	url, err := xurl.Normalize(req.URL.Redacted())
-	if err != nil {
-		url = req.URL.Redacted()
-	}
-
 	var builder strings.Builder
 
-	builder.Grow(len(name) + len(req.Method) + len(url) + len(extra)*2)
+	builder.Grow(len(name) + len(method) + len(url) + len(extra)*2)
 
 	builder.WriteString("name:")
 	builder.WriteString(name)
 	builder.WriteString(":method:")
-	builder.WriteString(req.Method)
+	builder.WriteString(method)
 	builder.WriteString(":url:")
 	builder.WriteString(url)
 
@@ -85,5 +115,5 @@ func Key(name string, req *http.Request, extra ...string) string {
 		}
 	}
 
-	return xfnv.String(builder.String())
+	return builder.String()
 }
@@ -1,6 +1,7 @@
 package pagecache
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
@@ -13,9 +14,16 @@ const (
 
 	// DefaultMaxBodySize is the default maximum size of a response body.
 	DefaultMaxBodySize int64 = 5 * 1024 * 1024
+
+	// DefaultMaxHeaderSize is the default maximum total size of the header
+	// fields of a response.
+	DefaultMaxHeaderSize int64 = 64 * 1024
 )
 
 // Policy defines under which conditions an HTTP response may be cached.
+//
+// A Policy may be copied, but, like its Rules, it must not be modified while
+// it is in use by a cache or Transport.
 type Policy struct {
 	// AllowedStatusCodes is a list of HTTP status codes that should be cached.
 	AllowedStatusCodes map[int]struct{} //nolint:revive // using int as key for performance
@@ -29,23 +37,100 @@ type Policy struct {
 	// ExcludedCookies is a list of HTTP cookies to exclude from caching.
 	ExcludedCookies map[string]struct{} //nolint:revive // see above
 
+	// StrippedHeaders is a list of HTTP headers that are always removed from
+	// a response before it is stored, such as hop-by-hop headers.
+	StrippedHeaders map[string]struct{} //nolint:revive // see above
+
 	// Rules is a list of rules to apply to a request in order to determine if it should be cached.
 	Rules []*Rule
 
 	// MaxBodySize is the maximum size of the response body allowed to be
 	// cached, in bytes. Zero or a negative value indicates no limit.
+	//
+	// The limit is enforced on the bytes actually read, so responses without
+	// a Content-Length header, such as chunked ones, stop being stored once
+	// they cross it, while the client still receives them in full.
 	MaxBodySize int64
 
+	// MaxHeaderSize is the maximum total size of the header fields of a
+	// response allowed to be cached, in bytes, counted as they are sent on
+	// the wire. Zero or a negative value indicates no limit.
+	MaxHeaderSize int64
+
+	// CredentialMode controls how requests carrying credentials, such as an
+	// Authorization header, URL userinfo, or a principal set with
+	// WithPrincipal, are cached. The zero value partitions them by credential
+	// identity.
+	CredentialMode CredentialMode
+
+	// TagFunc returns the tags of a response, used to invalidate entries by
+	// tag. If nil, tags are read from the Surrogate-Key and Cache-Tag
+	// headers. See Tagger.
+	TagFunc func(resp *http.Response) []string
+
+	// StripExcluded controls what happens to responses carrying any of the
+	// ExcludedHeaders or setting any of the ExcludedCookies. If false, such
+	// responses are not cached at all. If true, they are cached with those
+	// headers and Set-Cookie lines removed from the stored copy.
+	StripExcluded bool
+
 	// UseCacheControl controls whether the cache takes the Cache-Control header
-	// into account when deciding whether to cache a response.
+	// into account when deciding whether to cache a response, and whether
+	// Transport honors the no-cache and no-store request directives.
 	UseCacheControl bool
 
+	// TargetedHeaders lists, in order of precedence, targeted cache-control
+	// header fields, such as CDN-Cache-Control from RFC 9213 or the older
+	// Surrogate-Control, that override Cache-Control for this cache. If
+	// UseCacheControl is true, freshness and storability are read from the
+	// first of these fields present in a response, and Cache-Control is only
+	// used if none is.
+	TargetedHeaders []string
+
+	// StripTargetedHeaders controls whether Transport removes the
+	// TargetedHeaders from responses before returning them, so they don't
+	// reach clients or caches further downstream.
+	StripTargetedHeaders bool
+
 	// DefaultTTL is the default time-to-live of a cached response. Zero or a
 	// negative value is interpreted as no expiration.
 	//
 	// If UseCacheControl is true, the cache will use the header's value to
 	// determine the TTL instead.
 	DefaultTTL time.Duration
+
+	// StatusTTLs maps HTTP status codes to the time-to-live of responses with
+	// that status, taking precedence over StatusClassTTLs and DefaultTTL.
+	//
+	// A status code listed here is cacheable even if it is not part of
+	// AllowedStatusCodes, which allows, for example, negatively caching 404
+	// responses for a short time. If UseCacheControl is true, explicit
+	// freshness information from the origin still takes precedence.
+	StatusTTLs map[int]time.Duration //nolint:revive // see AllowedStatusCodes
+
+	// StatusClassTTLs maps HTTP status classes to the time-to-live of
+	// responses in that class, taking precedence over DefaultTTL. Classes are
+	// identified by their first digit, so 4 stands for every 4xx status code.
+	//
+	// Unlike StatusTTLs, listing a class here does not make its status codes
+	// cacheable. If UseCacheControl is true, explicit freshness information
+	// from the origin still takes precedence.
+	StatusClassTTLs map[int]time.Duration //nolint:revive // see AllowedStatusCodes
+
+	// MediaTypes maps media types, such as "text/html", and media ranges, such
+	// as "image/*" or "*/*", to the default TTL and body size limit of
+	// responses whose Content-Type matches them. Media types are expected in
+	// lowercase and without parameters.
+	//
+	// The media type TTL takes precedence over StatusClassTTLs and
+	// DefaultTTL, but not over StatusTTLs or, if UseCacheControl is true,
+	// explicit freshness information from the origin.
+	MediaTypes map[string]MediaTypePolicy
+
+	// rules holds the compiled form of Rules once Validate has been called.
+	// It is only used while Rules still holds the rules it was compiled
+	// from, so edits made without calling Validate again are not missed.
+	rules *ruleSet
 }
 
 // DefaultPolicy returns a new *Policy with opinionated but sane defaults.
@@ -74,9 +159,14 @@ func DefaultPolicy() *Policy {
 		ExcludedCookies: map[string]struct{}{
 			"sessionid": {},
 		},
+		StrippedHeaders: DefaultStrippedHeaders(),
 		Rules:           []*Rule{},
 		MaxBodySize:     DefaultMaxBodySize,
+		MaxHeaderSize:   DefaultMaxHeaderSize,
 		DefaultTTL:      DefaultTTL,
+		StatusTTLs:      map[int]time.Duration{},
+		StatusClassTTLs: map[int]time.Duration{},
+		MediaTypes:      map[string]MediaTypePolicy{},
 		UseCacheControl: true,
 	}
 }
@@ -86,7 +176,7 @@ func DefaultPolicy() *Policy {
 //
 // Returns true if the request and response should be cached, otherwise false.
 func (p *Policy) IsCacheable(resp *http.Response) bool {
-	if _, ok := p.AllowedStatusCodes[resp.StatusCode]; !ok {
+	if !p.isCacheableStatus(resp.StatusCode) {
 		return false
 	}
 
@@ -94,33 +184,43 @@ func (p *Policy) IsCacheable(resp *http.Response) bool {
 		return false
 	}
 
-	if !p.isCacheableHeaders(resp.Header) {
+	if !isCacheableCredentials(p.CredentialMode, Credentials(resp.Request)) {
 		return false
 	}
 
-	if !p.isCacheableCookies(resp.Cookies()) {
+	if !p.StripExcluded && !p.isCacheableHeaders(resp.Header) {
 		return false
 	}
 
-	if !httputil.IsBodySizeWithinLimit(resp.Header, p.MaxBodySize) {
+	if !p.StripExcluded && !p.isCacheableCookies(resp.Cookies()) {
 		return false
 	}
 
-	for _, rule := range p.Rules {
-		if rule.Match(resp.Request.URL.String()) {
-			if rule.Behavior == BehaviorExclude {
-				return false
-			}
+	if !httputil.IsBodySizeWithinLimit(resp.Header, p.BodySizeLimit(resp)) {
+		return false
+	}
 
-			break
-		}
+	if !httputil.IsHeaderSizeWithinLimit(resp.Header, p.MaxHeaderSize) {
+		return false
+	}
+
+	// Responses varying on "*" never match a later request, following RFC
+	// 9111, Section 4.1.
+	if _, ok := varyFields(resp.Header); !ok {
+		return false
+	}
+
+	if rule := p.matchRule(resp.Request); rule != nil && rule.Behavior == BehaviorExclude {
+		return false
 	}
 
 	if p.UseCacheControl {
-		cacheControl := resp.Header.Get("Cache-Control")
+		cacheControl := p.CacheControl(resp.Header)
 
-		if cacheControl == "no-store" || cacheControl == "no-cache" || cacheControl == "private" {
-			return false
+		for _, directive := range []string{"no-store", "no-cache", "private"} {
+			if httputil.HasDirective(cacheControl, directive) {
+				return false
+			}
 		}
 	}
 
@@ -132,22 +232,144 @@ func (p *Policy) IsCacheable(resp *http.Response) bool {
 	return true
 }
 
+// Validate compiles every rule in the policy and stores the result, so that
+// subsequent calls to IsCacheable match rules without locking or recompiling
+// patterns. If Rules is modified afterwards, rules are matched one by one with
+// Rule.Match until Validate is called again. Validate must not be called
+// concurrently with IsCacheable.
+//
+// Validate reports every invalid rule, not only the first one. The returned
+// error wraps one *RuleError per invalid rule and can be inspected with
+// errors.As. Invalid rules never match, just like with Rule.Match.
+func (p *Policy) Validate() error {
+	set, errs := compileRules(p.Rules)
+
+	p.rules = set
+
+	return errors.Join(errs...)
+}
+
 // TTL returns the time-to-live (TTL) for the given response according to the
 // policy. If the policy is configured to use the Cache-Control header and the
 // header contains a valid max-age directive, the TTL will be based on that value.
-// Otherwise, the policy's default TTL will be used.
+// Otherwise, the TTL configured for the response's status code in StatusTTLs is
+// used, then the one configured for its media type in MediaTypes, then the one
+// configured for its status class in StatusClassTTLs, and finally the policy's
+// default TTL.
 func (p *Policy) TTL(resp *http.Response) time.Duration {
-	if p.UseCacheControl {
-		maxAge := httputil.MaxAge(resp.Header)
+	if ttl, ok := p.originTTL(resp); ok {
+		return ttl
+	}
 
-		if maxAge != -1 {
-			return time.Duration(maxAge) * time.Second
-		}
+	if ttl, ok := p.StatusTTLs[resp.StatusCode]; ok {
+		return ttl
+	}
+
+	if mtp, ok := p.mediaTypePolicy(resp.Header); ok && mtp.TTL != 0 {
+		return mtp.TTL
+	}
+
+	if ttl, ok := p.StatusClassTTLs[resp.StatusCode/100]; ok {
+		return ttl
 	}
 
 	return p.DefaultTTL
 }
 
+// BodySizeLimit returns the maximum size of the given response's body allowed
+// to be cached, in bytes, taking the limit configured for its media type in
+// MediaTypes into account. Zero or a negative value indicates no limit.
+func (p *Policy) BodySizeLimit(resp *http.Response) int64 {
+	if mtp, ok := p.mediaTypePolicy(resp.Header); ok && mtp.MaxBodySize != 0 {
+		return mtp.MaxBodySize
+	}
+
+	return p.MaxBodySize
+}
+
+// CacheControl returns a header holding, as Cache-Control, the cache-control
+// directives the policy applies to a response with the given header: those of
+// the first TargetedHeaders field present in it or, if there is none, those of
+// its Cache-Control field.
+func (p *Policy) CacheControl(header http.Header) http.Header {
+	for _, name := range p.TargetedHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			return http.Header{"Cache-Control": values}
+		}
+	}
+
+	return header
+}
+
+// StripTargeted removes the TargetedHeaders from the given header if
+// StripTargetedHeaders is true.
+func (p *Policy) StripTargeted(header http.Header) {
+	if !p.StripTargetedHeaders {
+		return
+	}
+
+	for _, name := range p.TargetedHeaders {
+		header.Del(name)
+	}
+}
+
+// originTTL returns the time-to-live given by the origin's explicit freshness
+// information, if the policy is configured to use it and the response has
+// any.
+func (p *Policy) originTTL(resp *http.Response) (time.Duration, bool) {
+	if !p.UseCacheControl {
+		return 0, false
+	}
+
+	maxAge := httputil.MaxAge(p.CacheControl(resp.Header))
+	if maxAge == -1 {
+		return 0, false
+	}
+
+	return time.Duration(maxAge) * time.Second, true
+}
+
+// isCacheableStatus checks if the given status code is cacheable according to
+// the policy.
+func (p *Policy) isCacheableStatus(code int) bool {
+	if _, ok := p.AllowedStatusCodes[code]; ok {
+		return true
+	}
+
+	_, ok := p.StatusTTLs[code]
+
+	return ok
+}
+
+// MatchRule returns the first rule matching the request URL, or nil if no
+// rule matches.
+func (p *Policy) MatchRule(req *http.Request) *Rule {
+	return p.matchRule(req)
+}
+
+// matchRule returns the first rule matching the request URL, or nil if no rule
+// matches. It uses the rules compiled by Validate when they are still current,
+// and falls back to Rule.Match otherwise.
+func (p *Policy) matchRule(req *http.Request) *Rule {
+	if len(p.Rules) == 0 || req.URL == nil {
+		return nil
+	}
+
+	url := req.URL.String()
+
+	if set := p.rules; set.compiledFrom(p.Rules) {
+		return set.match(url)
+	}
+
+	for _, rule := range p.Rules {
+		if rule.Match(url) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
 // isCacheableHeaders checks if the given headers are cacheable according to the policy.
 func (p *Policy) isCacheableHeaders(headers http.Header) bool {
 	for header := range headers {
@@ -0,0 +1,110 @@
+package pagecache_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+const (
+	_benchmarkRuleCount = 1000
+
+	// _benchmarkMatchingURL matches one of the last arbitrary patterns of the
+	// benchmark policy, the worst case for a linear scan.
+	_benchmarkMatchingURL = "https://example.com/item-998/42"
+)
+
+func BenchmarkPolicy_IsCacheable(b *testing.B) {
+	benchmarks := []struct {
+		name     string
+		url      string
+		validate bool
+	}{
+		{
+			name:     "Linear",
+			url:      "https://example.com/unmatched/page",
+			validate: false,
+		},
+		{
+			name:     "Indexed",
+			url:      "https://example.com/unmatched/page",
+			validate: true,
+		},
+		{
+			name:     "Linear matching pattern",
+			url:      _benchmarkMatchingURL,
+			validate: false,
+		},
+		{
+			name:     "Indexed matching pattern",
+			url:      _benchmarkMatchingURL,
+			validate: true,
+		},
+	}
+
+	for _, bm := range benchmarks {
+		bm := bm
+
+		b.Run(bm.name, func(b *testing.B) {
+			p := benchmarkPolicy(b, _benchmarkRuleCount)
+
+			if bm.validate {
+				if err := p.Validate(); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			uri, err := url.Parse(bm.url)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			resp := &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+					URL:    uri,
+				},
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				p.IsCacheable(resp)
+			}
+		})
+	}
+}
+
+// benchmarkPolicy returns a policy with count rules, split evenly between exact
+// URLs, literal prefixes, and arbitrary patterns.
+func benchmarkPolicy(b *testing.B, count int) *pagecache.Policy {
+	b.Helper()
+
+	p := pagecache.DefaultPolicy()
+	p.Rules = make([]*pagecache.Rule, 0, count)
+
+	for i := 0; i < count; i++ {
+		var rule *pagecache.Rule
+
+		switch i % 3 {
+		case 0:
+			rule = &pagecache.Rule{URL: fmt.Sprintf("https://example.com/page/%d", i)}
+		case 1:
+			rule = &pagecache.Rule{Pattern: fmt.Sprintf(`^https://example\.com/section/%d/`, i)}
+		default:
+			rule = &pagecache.Rule{Pattern: fmt.Sprintf(`/item-%d/\d+$`, i)}
+		}
+
+		rule.Behavior = pagecache.BehaviorExclude
+
+		p.Rules = append(p.Rules, rule)
+	}
+
+	return p
+}
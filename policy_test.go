@@ -1,12 +1,16 @@
 package pagecache_test
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/recache-go"
 )
 
 func TestPolicy_IsCacheable(t *testing.T) {
@@ -98,6 +102,44 @@ func TestPolicy_IsCacheable(t *testing.T) {
 			},
 			expectedResult: false,
 		},
+		{
+			name: "IsCacheable with max header size exceeded",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.MaxHeaderSize = 100
+				return p
+			}(),
+			response: &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+				},
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Link": []string{strings.Repeat("a", 100)},
+				},
+			},
+			expectedResult: false,
+		},
+		{
+			name: "IsCacheable with excluded cookie stripped",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.StripExcluded = true
+				p.ExcludedCookies["testcookie"] = struct{}{}
+				return p
+			}(),
+			response: &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+				},
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Content-Length": []string{"1000"},
+					"Set-Cookie":     []string{"testcookie=value"},
+				},
+			},
+			expectedResult: true,
+		},
 		{
 			name: "IsCacheable with max body size exceeded",
 			policy: func() *pagecache.Policy {
@@ -131,6 +173,61 @@ func TestPolicy_IsCacheable(t *testing.T) {
 			},
 			expectedResult: false,
 		},
+		{
+			name:   "IsCacheable with Cache-Control no-store among other directives",
+			policy: pagecache.DefaultPolicy(),
+			response: &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+				},
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Content-Length": []string{"1000"},
+					"Cache-Control":  []string{"public, max-age=60, no-store"},
+				},
+			},
+			expectedResult: false,
+		},
+		{
+			name: "IsCacheable with targeted header overriding Cache-Control",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.TargetedHeaders = []string{"CDN-Cache-Control", "Surrogate-Control"}
+				return p
+			}(),
+			response: &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+				},
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Content-Length":    []string{"1000"},
+					"Cache-Control":     []string{"private"},
+					"Cdn-Cache-Control": []string{"max-age=60"},
+				},
+			},
+			expectedResult: true,
+		},
+		{
+			name: "IsCacheable with targeted no-store",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.TargetedHeaders = []string{"CDN-Cache-Control", "Surrogate-Control"}
+				return p
+			}(),
+			response: &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+				},
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Content-Length":    []string{"1000"},
+					"Cache-Control":     []string{"max-age=60"},
+					"Surrogate-Control": []string{"no-store"},
+				},
+			},
+			expectedResult: false,
+		},
 		{
 			name:   "IsCacheable with Cache-Control private",
 			policy: pagecache.DefaultPolicy(),
@@ -190,6 +287,38 @@ func TestPolicy_IsCacheable(t *testing.T) {
 			},
 			expectedResult: true,
 		},
+		{
+			name: "IsCacheable with status code TTL",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.StatusTTLs[http.StatusNotFound] = 30 * time.Second
+				return p
+			}(),
+			response: &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+				},
+				StatusCode: http.StatusNotFound,
+				Header:     http.Header{},
+			},
+			expectedResult: true,
+		},
+		{
+			name: "IsCacheable with status class TTL only",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.StatusClassTTLs[4] = 30 * time.Second
+				return p
+			}(),
+			response: &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+				},
+				StatusCode: http.StatusNotFound,
+				Header:     http.Header{},
+			},
+			expectedResult: false,
+		},
 		{
 			name: "IsCacheable with negative TTL",
 			policy: func() *pagecache.Policy {
@@ -225,6 +354,208 @@ func TestPolicy_IsCacheable(t *testing.T) {
 	}
 }
 
+func TestPolicy_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		rules       []*pagecache.Rule
+		wantIndexes []int
+	}{
+		{
+			name:        "No rules",
+			rules:       []*pagecache.Rule{},
+			wantIndexes: nil,
+		},
+		{
+			name: "Valid rules",
+			rules: []*pagecache.Rule{
+				{URL: "https://example.com/"},
+				{Pattern: `^https://example\.com/\w+$`},
+			},
+			wantIndexes: nil,
+		},
+		{
+			name: "Invalid rules",
+			rules: []*pagecache.Rule{
+				{Pattern: "[invalid"},
+				{URL: "https://example.com/"},
+				{Behavior: pagecache.BehaviorExclude},
+				{Pattern: "(unclosed"},
+			},
+			wantIndexes: []int{0, 2, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := pagecache.DefaultPolicy()
+			p.Rules = tt.rules
+
+			err := p.Validate()
+			if len(tt.wantIndexes) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatal("Validate() expected error, got nil")
+			}
+
+			joined, ok := err.(interface{ Unwrap() []error }) //nolint:errorlint // checking for joined errors
+			if !ok {
+				t.Fatalf("Validate() error does not wrap multiple errors: %v", err)
+			}
+
+			errs := joined.Unwrap()
+			if len(errs) != len(tt.wantIndexes) {
+				t.Fatalf("Validate() returned %d errors, want %d", len(errs), len(tt.wantIndexes))
+			}
+
+			for i, e := range errs {
+				var ruleErr *pagecache.RuleError
+				if !errors.As(e, &ruleErr) {
+					t.Fatalf("error %d is not a *RuleError: %v", i, e)
+				}
+
+				if ruleErr.Index != tt.wantIndexes[i] {
+					t.Errorf("error %d has index %d, want %d", i, ruleErr.Index, tt.wantIndexes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPolicy_IsCacheable_ValidatedRules(t *testing.T) {
+	t.Parallel()
+
+	p := pagecache.DefaultPolicy()
+	p.Rules = []*pagecache.Rule{
+		{Pattern: "[invalid", Behavior: pagecache.BehaviorExclude},
+		{Pattern: `^http://example\.com/private/`, Behavior: pagecache.BehaviorExclude},
+		{Pattern: `^http://example\.com/`, Behavior: pagecache.BehaviorInclude},
+	}
+
+	if err := p.Validate(); err == nil {
+		t.Fatal("Validate() expected error for invalid rule, got nil")
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want bool
+	}{
+		{
+			name: "Excluded by pattern",
+			url:  "http://example.com/private/page",
+			want: false,
+		},
+		{
+			name: "Included by pattern",
+			url:  "http://example.com/public/page",
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+					URL:    parseTestURL(t, tt.url),
+				},
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+			}
+
+			if got := p.IsCacheable(resp); got != tt.want {
+				t.Errorf("IsCacheable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_IsCacheable_RulesEditedAfterValidate(t *testing.T) {
+	t.Parallel()
+
+	newResponse := func(t *testing.T) *http.Response {
+		t.Helper()
+
+		return &http.Response{
+			Request: &http.Request{
+				Method: http.MethodGet,
+				URL:    parseTestURL(t, "http://example.com/private/page"),
+			},
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+		}
+	}
+
+	exclude := &pagecache.Rule{Pattern: `^http://example\.com/private/`, Behavior: pagecache.BehaviorExclude}
+
+	tests := []struct {
+		name string
+		edit func(p *pagecache.Policy)
+		want bool
+	}{
+		{
+			name: "Rule appended",
+			edit: func(p *pagecache.Policy) {
+				p.Rules = append(p.Rules, exclude)
+			},
+			want: false,
+		},
+		{
+			name: "Rule replaced in place",
+			edit: func(p *pagecache.Policy) {
+				p.Rules[0] = exclude
+			},
+			want: false,
+		},
+		{
+			name: "Rules removed",
+			edit: func(p *pagecache.Policy) {
+				p.Rules = nil
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := *pagecache.DefaultPolicy()
+			p.Rules = []*pagecache.Rule{
+				{URL: "http://example.com/other", Behavior: pagecache.BehaviorExclude},
+			}
+
+			if err := p.Validate(); err != nil {
+				t.Fatalf("Validate() unexpected error: %v", err)
+			}
+
+			tt.edit(&p)
+
+			if got := p.IsCacheable(newResponse(t)); got != tt.want {
+				t.Errorf("IsCacheable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestPolicy_TTL(t *testing.T) {
 	t.Parallel()
 
@@ -261,6 +592,174 @@ func TestPolicy_TTL(t *testing.T) {
 			},
 			expectedResult: 3600 * time.Second,
 		},
+		{
+			name: "TTL with status code TTL",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.StatusTTLs[http.StatusNotFound] = 30 * time.Second
+				p.StatusClassTTLs[4] = 5 * time.Minute
+				return p
+			}(),
+			response: &http.Response{
+				StatusCode: http.StatusNotFound,
+				Header:     http.Header{},
+			},
+			expectedResult: 30 * time.Second,
+		},
+		{
+			name: "TTL with status class TTL",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.StatusTTLs[http.StatusNotFound] = 30 * time.Second
+				p.StatusClassTTLs[4] = 5 * time.Minute
+				return p
+			}(),
+			response: &http.Response{
+				StatusCode: http.StatusGone,
+				Header:     http.Header{},
+			},
+			expectedResult: 5 * time.Minute,
+		},
+		{
+			name: "TTL with status code TTL and Cache-Control max-age",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.StatusTTLs[http.StatusMovedPermanently] = 24 * time.Hour
+				return p
+			}(),
+			response: &http.Response{
+				StatusCode: http.StatusMovedPermanently,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=60"},
+				},
+			},
+			expectedResult: 60 * time.Second,
+		},
+		{
+			name: "TTL with status code TTL and Cache-Control ignored",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.UseCacheControl = false
+				p.StatusTTLs[http.StatusMovedPermanently] = 24 * time.Hour
+				return p
+			}(),
+			response: &http.Response{
+				StatusCode: http.StatusMovedPermanently,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=60"},
+				},
+			},
+			expectedResult: 24 * time.Hour,
+		},
+		{
+			name: "TTL with exact media type",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.MediaTypes["application/json"] = pagecache.MediaTypePolicy{TTL: 10 * time.Second}
+				p.MediaTypes["application/*"] = pagecache.MediaTypePolicy{TTL: time.Minute}
+				return p
+			}(),
+			response: &http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Content-Type": []string{"Application/JSON; charset=utf-8"},
+				},
+			},
+			expectedResult: 10 * time.Second,
+		},
+		{
+			name: "TTL with wildcard media range",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.MediaTypes["image/*"] = pagecache.MediaTypePolicy{TTL: 30 * 24 * time.Hour}
+				p.MediaTypes["*/*"] = pagecache.MediaTypePolicy{TTL: time.Minute}
+				return p
+			}(),
+			response: &http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Content-Type": []string{"image/png"},
+				},
+			},
+			expectedResult: 30 * 24 * time.Hour,
+		},
+		{
+			name: "TTL with media type and Cache-Control max-age",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.MediaTypes["text/html"] = pagecache.MediaTypePolicy{TTL: 5 * time.Minute}
+				return p
+			}(),
+			response: &http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Cache-Control": []string{"max-age=60"},
+					"Content-Type":  []string{"text/html"},
+				},
+			},
+			expectedResult: 60 * time.Second,
+		},
+		{
+			name: "TTL with media type without TTL",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.MediaTypes["text/html"] = pagecache.MediaTypePolicy{MaxBodySize: 1024}
+				return p
+			}(),
+			response: &http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Content-Type": []string{"text/html"},
+				},
+			},
+			expectedResult: pagecache.DefaultTTL,
+		},
+		{
+			name: "TTL with targeted headers in order of precedence",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.TargetedHeaders = []string{"CDN-Cache-Control", "Surrogate-Control"}
+				return p
+			}(),
+			response: &http.Response{
+				Header: http.Header{
+					"Cache-Control":     []string{"max-age=60"},
+					"Cdn-Cache-Control": []string{"max-age=600"},
+					"Surrogate-Control": []string{"max-age=6000"},
+				},
+			},
+			expectedResult: 600 * time.Second,
+		},
+		{
+			name: "TTL with targeted header without max-age",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.TargetedHeaders = []string{"CDN-Cache-Control"}
+				return p
+			}(),
+			response: &http.Response{
+				Header: http.Header{
+					"Cache-Control":     []string{"max-age=60"},
+					"Cdn-Cache-Control": []string{"public"},
+				},
+			},
+			expectedResult: pagecache.DefaultTTL,
+		},
+		{
+			name: "TTL with targeted headers ignored without UseCacheControl",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.UseCacheControl = false
+				p.TargetedHeaders = []string{"CDN-Cache-Control"}
+				return p
+			}(),
+			response: &http.Response{
+				Header: http.Header{
+					"Cdn-Cache-Control": []string{"max-age=600"},
+				},
+			},
+			expectedResult: pagecache.DefaultTTL,
+		},
 		{
 			name: "TTL with Cache-Control max-age and invalid value",
 			policy: func() *pagecache.Policy {
@@ -291,6 +790,67 @@ func TestPolicy_TTL(t *testing.T) {
 	}
 }
 
+func TestPolicy_BodySizeLimit(t *testing.T) {
+	t.Parallel()
+
+	p := pagecache.DefaultPolicy()
+	p.MediaTypes["video/*"] = pagecache.MediaTypePolicy{MaxBodySize: -1}
+	p.MediaTypes["text/html"] = pagecache.MediaTypePolicy{MaxBodySize: 1024}
+	p.MediaTypes["application/json"] = pagecache.MediaTypePolicy{TTL: time.Second}
+
+	tests := []struct {
+		name        string
+		contentType string
+		want        int64
+	}{
+		{
+			name:        "No Content-Type",
+			contentType: "",
+			want:        pagecache.DefaultMaxBodySize,
+		},
+		{
+			name:        "Exact media type",
+			contentType: "text/html; charset=utf-8",
+			want:        1024,
+		},
+		{
+			name:        "Wildcard media range without limit",
+			contentType: "video/mp4",
+			want:        -1,
+		},
+		{
+			name:        "Media type without limit",
+			contentType: "application/json",
+			want:        pagecache.DefaultMaxBodySize,
+		},
+		{
+			name:        "Invalid Content-Type",
+			contentType: "/",
+			want:        pagecache.DefaultMaxBodySize,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := &http.Response{
+				Header: http.Header{},
+			}
+
+			if tt.contentType != "" {
+				resp.Header.Set("Content-Type", tt.contentType)
+			}
+
+			if got := p.BodySizeLimit(resp); got != tt.want {
+				t.Errorf("BodySizeLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
 func parseTestURL(t *testing.T, urlStr string) *url.URL {
 	t.Helper()
 
@@ -301,3 +861,141 @@ func parseTestURL(t *testing.T, urlStr string) *url.URL {
 
 	return uri
 }
+
+func TestPolicy_Validate_FirstMatch(t *testing.T) {
+	t.Parallel()
+
+	rules := []*pagecache.Rule{
+		{Pattern: `^https://example\.com/blog/2023/`, Behavior: pagecache.BehaviorExclude},
+		{Pattern: `/drafts?/`, Behavior: pagecache.BehaviorExclude},
+		{URL: "https://example.com/blog/", Behavior: pagecache.BehaviorInclude},
+		{Pattern: `^https://example\.com/blog/.*`, Behavior: pagecache.BehaviorInclude},
+		{Pattern: `^https://example\.com/about$`, Behavior: pagecache.BehaviorExclude},
+		{Pattern: `(?i)\.PDF$`, Behavior: pagecache.BehaviorExclude},
+		{Pattern: `^https://example\.com/(\w+)/(\d+)$`, Behavior: pagecache.BehaviorInclude},
+		{Pattern: `^https://example\.com/`, Behavior: pagecache.BehaviorExclude},
+		// Matches earlier in every URL than the patterns above it, which must
+		// still take precedence.
+		{Pattern: `https?:`, Behavior: pagecache.BehaviorInclude},
+		{Pattern: `example`, PatternFlag: recache.FlagPOSIX, Behavior: pagecache.BehaviorInclude},
+	}
+
+	urls := []string{
+		"https://example.com/blog/2023/post",
+		"https://example.com/blog/draft/post",
+		"https://example.com/blog/",
+		"https://example.com/blog/2022/post",
+		"https://example.com/about",
+		"https://example.com/about/team",
+		"https://example.com/files/report.pdf",
+		"https://example.com/items/42",
+		"https://example.com/items/abc",
+		"https://example.org/",
+		"https://example.org/drafts/1",
+		"https://example.org/docs/manual.PDF",
+		"https://other.org/",
+	}
+
+	p := pagecache.DefaultPolicy()
+	p.UseCacheControl = false
+	p.Rules = rules
+
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
+
+	for _, u := range urls {
+		u := u
+
+		t.Run(u, func(t *testing.T) {
+			t.Parallel()
+
+			want := true
+
+			for _, rule := range rules {
+				if rule.Match(u) {
+					want = rule.Behavior != pagecache.BehaviorExclude
+
+					break
+				}
+			}
+
+			resp := &http.Response{
+				Request: &http.Request{
+					Method: http.MethodGet,
+					URL:    parseTestURL(t, u),
+				},
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+			}
+
+			if got := p.IsCacheable(resp); got != want {
+				t.Errorf("IsCacheable() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Validate_FirstMatch_ManyPatterns(t *testing.T) {
+	t.Parallel()
+
+	// Every URL below matches several patterns, at different positions, so
+	// only the first rule in order must decide.
+	var rules []*pagecache.Rule
+
+	for i := 0; i < 50; i++ {
+		behavior := pagecache.BehaviorInclude
+		if i%2 == 0 {
+			behavior = pagecache.BehaviorExclude
+		}
+
+		rules = append(rules, &pagecache.Rule{
+			Pattern:  fmt.Sprintf(`/item-%d(/|$)`, i),
+			Behavior: behavior,
+		})
+	}
+
+	rules = append(rules,
+		&pagecache.Rule{Pattern: `/item-\d+/page-\d+$`, Behavior: pagecache.BehaviorExclude},
+		&pagecache.Rule{Pattern: `https?://`, Behavior: pagecache.BehaviorInclude},
+	)
+
+	p := pagecache.DefaultPolicy()
+	p.UseCacheControl = false
+	p.Rules = rules
+
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate() unexpected error: %v", err)
+	}
+
+	for _, u := range []string{
+		"https://example.com/item-7",
+		"https://example.com/item-48/item-13",
+		"https://example.com/item-99/page-2",
+		"https://example.com/item-99/item-1/page-3",
+		"https://example.com/other",
+		"ftp://example.com/other",
+	} {
+		u := u
+
+		t.Run(u, func(t *testing.T) {
+			t.Parallel()
+
+			var want *pagecache.Rule
+
+			for _, rule := range rules {
+				if rule.Match(u) {
+					want = rule
+
+					break
+				}
+			}
+
+			req := &http.Request{Method: http.MethodGet, URL: parseTestURL(t, u)}
+
+			if got := p.MatchRule(req); got != want {
+				t.Errorf("MatchRule() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package pagecache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~jamesponddotco/xstd-go/xerrors"
+)
+
+const (
+	// ErrInvalidRange is returned when a Range header cannot be parsed.
+	ErrInvalidRange xerrors.Error = "invalid range"
+
+	// ErrUnsatisfiableRange is returned when none of the ranges of a Range
+	// header overlap the representation.
+	ErrUnsatisfiableRange xerrors.Error = "unsatisfiable range"
+)
+
+// byteRange is a range of bytes of a representation.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange returns the Content-Range header value of the range, for a
+// representation of the given size.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header value, as defined by RFC 9110, Section
+// 14.2, for a representation of the given size. Ranges not overlapping the
+// representation are ignored, and ErrUnsatisfiableRange is returned if none
+// does.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(value, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, ErrInvalidRange
+	}
+
+	var (
+		ranges []byteRange
+		parsed int
+	)
+
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, ErrInvalidRange
+		}
+
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		parsed++
+
+		if first == "" {
+			// A suffix range selects the last bytes of the representation.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, ErrInvalidRange
+			}
+
+			if n == 0 || size == 0 {
+				continue
+			}
+
+			if n > size {
+				n = size
+			}
+
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, ErrInvalidRange
+		}
+
+		end := size - 1
+
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, ErrInvalidRange
+			}
+
+			if end > size-1 {
+				end = size - 1
+			}
+		}
+
+		if start >= size {
+			continue
+		}
+
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	if parsed == 0 {
+		return nil, ErrInvalidRange
+	}
+
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+
+	return ranges, nil
+}
+
+// ifRangeMatches reports whether the If-Range header of the request, if any,
+// matches the given response, following RFC 9110, Section 13.1.5. An entity
+// tag matches only when both are strong and equal, and a date only when it
+// equals the Last-Modified date.
+func ifRangeMatches(req *http.Request, resp *http.Response) bool {
+	value := strings.TrimSpace(req.Header.Get("If-Range"))
+	if value == "" {
+		return true
+	}
+
+	if strings.HasPrefix(value, `"`) || isWeakETag(value) {
+		return matchETag(value, resp.Header.Get("ETag"), false)
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+
+	return date.Equal(lastModified)
+}
+
+// serveRange returns the response answering the Range header of the given
+// request from the given complete 200 response: a 206 response holding the
+// requested range, or a multipart/byteranges body for several ranges, or a
+// 416 response if no range is satisfiable. The complete response is returned
+// as is if the request has no valid Range header, or if its If-Range
+// precondition fails.
+func serveRange(req *http.Request, resp *http.Response) (*http.Response, error) {
+	value := req.Header.Get("Range")
+
+	if req.Method != http.MethodGet || value == "" || resp.StatusCode != http.StatusOK || !ifRangeMatches(req, resp) {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	resp.Body.Close()
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	size := int64(len(body))
+
+	ranges, err := parseRange(value, size)
+	if err != nil {
+		if errors.Is(err, ErrUnsatisfiableRange) {
+			return unsatisfiableResponse(resp, size), nil
+		}
+
+		// Invalid Range headers are ignored.
+		return resp, nil
+	}
+
+	if partial, ok := rangeResponse(resp, resp.Header.Get("Content-Type"), size, ranges, func(r byteRange) []byte {
+		return body[r.start : r.start+r.length]
+	}); ok {
+		return partial, nil
+	}
+
+	return resp, nil
+}
+
+// unsatisfiableResponse returns the 416 response to a request whose ranges
+// don't overlap a representation of the given size, based on the given
+// response.
+func unsatisfiableResponse(resp *http.Response, size int64) *http.Response {
+	partial := partialResponse(resp, http.StatusRequestedRangeNotSatisfiable, nil)
+	partial.Header.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+	partial.Header.Del("Content-Type")
+
+	return partial
+}
+
+// rangeResponse returns the 206 response holding the given ranges of a
+// representation of the given size and content type, based on the given
+// response, reading the bytes of each range with the given function. It
+// returns false if the ranges add up to more than the representation itself,
+// in which case serving the complete representation is preferable.
+func rangeResponse(resp *http.Response, contentType string, size int64, ranges []byteRange, read func(r byteRange) []byte) (*http.Response, bool) {
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+
+	if total > size {
+		return nil, false
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+
+		partial := partialResponse(resp, http.StatusPartialContent, read(r))
+		partial.Header.Set("Content-Range", r.contentRange(size))
+
+		if contentType != "" {
+			partial.Header.Set("Content-Type", contentType)
+		}
+
+		return partial, true
+	}
+
+	var (
+		buf bytes.Buffer
+		w   = multipart.NewWriter(&buf)
+	)
+
+	for _, r := range ranges {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Range", r.contentRange(size))
+
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+
+		// Writing to a bytes.Buffer never fails.
+		part, _ := w.CreatePart(header) //nolint:errcheck // see above
+		_, _ = part.Write(read(r))      //nolint:errcheck // see above
+	}
+
+	_ = w.Close() //nolint:errcheck // see above
+
+	partial := partialResponse(resp, http.StatusPartialContent, buf.Bytes())
+	partial.Header.Set("Content-Type", "multipart/byteranges; boundary="+w.Boundary())
+	partial.Header.Del("Content-Range")
+
+	return partial, true
+}
+
+// partialResponse returns a copy of the given response with its own header,
+// and the given status and body.
+func partialResponse(resp *http.Response, status int, body []byte) *http.Response {
+	partial := *resp
+	partial.StatusCode = status
+	partial.Status = strconv.Itoa(status) + " " + http.StatusText(status)
+	partial.Header = resp.Header.Clone()
+	partial.Body = io.NopCloser(bytes.NewReader(body))
+	partial.ContentLength = int64(len(body))
+
+	partial.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	partial.Header.Set("Accept-Ranges", "bytes")
+
+	return &partial
+}
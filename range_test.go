@@ -0,0 +1,201 @@
+package pagecache_test
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+func TestTransport_RoundTrip_Range(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2023 15:04:05 GMT")
+
+		// The origin ignores ranges, so any partial response comes from the
+		// cache.
+		io.WriteString(w, "0123456789") //nolint:errcheck // test server
+	}))
+	defer ts.Close()
+
+	client := &http.Client{
+		Transport: pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport),
+	}
+
+	do := func(t *testing.T, header http.Header) (*http.Response, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/file", http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header = header
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return resp, string(body)
+	}
+
+	// Store the complete response.
+	do(t, http.Header{})
+
+	tests := []struct {
+		name             string
+		header           http.Header
+		wantStatus       int
+		wantBody         string
+		wantContentRange string
+	}{
+		{
+			name:             "Single range",
+			header:           http.Header{"Range": {"bytes=0-3"}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         "0123",
+			wantContentRange: "bytes 0-3/10",
+		},
+		{
+			name:             "Suffix range",
+			header:           http.Header{"Range": {"bytes=-3"}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         "789",
+			wantContentRange: "bytes 7-9/10",
+		},
+		{
+			name:             "Open range past the end",
+			header:           http.Header{"Range": {"bytes=5-100"}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         "56789",
+			wantContentRange: "bytes 5-9/10",
+		},
+		{
+			name:             "Unsatisfiable range",
+			header:           http.Header{"Range": {"bytes=20-30"}},
+			wantStatus:       http.StatusRequestedRangeNotSatisfiable,
+			wantContentRange: "bytes */10",
+		},
+		{
+			name:       "Invalid range",
+			header:     http.Header{"Range": {"items=0-1"}},
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+		},
+		{
+			name:             "Matching If-Range entity tag",
+			header:           http.Header{"Range": {"bytes=0-0"}, "If-Range": {`"v1"`}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         "0",
+			wantContentRange: "bytes 0-0/10",
+		},
+		{
+			name:             "Matching If-Range date",
+			header:           http.Header{"Range": {"bytes=0-0"}, "If-Range": {"Mon, 02 Jan 2023 15:04:05 GMT"}},
+			wantStatus:       http.StatusPartialContent,
+			wantBody:         "0",
+			wantContentRange: "bytes 0-0/10",
+		},
+		{
+			name:       "Different If-Range entity tag",
+			header:     http.Header{"Range": {"bytes=0-0"}, "If-Range": {`"v2"`}},
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+		},
+		{
+			name:       "Weak If-Range entity tag",
+			header:     http.Header{"Range": {"bytes=0-0"}, "If-Range": {`W/"v1"`}},
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := do(t, tt.header)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+
+			if got := resp.Header.Get("Content-Range"); got != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantContentRange)
+			}
+		})
+	}
+
+	t.Run("Multiple ranges", func(t *testing.T) {
+		resp, body := do(t, http.Header{"Range": {"bytes=0-1,-2"}})
+
+		if resp.StatusCode != http.StatusPartialContent {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusPartialContent)
+		}
+
+		mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Content-Type = %q, want multipart/byteranges", resp.Header.Get("Content-Type"))
+		}
+
+		want := []struct{ contentRange, body string }{
+			{"bytes 0-1/10", "01"},
+			{"bytes 8-9/10", "89"},
+		}
+
+		reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+
+		for i, w := range want {
+			part, err := reader.NextPart()
+			if err != nil {
+				t.Fatalf("part %d: %v", i, err)
+			}
+
+			data, err := io.ReadAll(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := part.Header.Get("Content-Range"); got != w.contentRange || string(data) != w.body {
+				t.Errorf("part %d = %q %q, want %q %q", i, got, data, w.contentRange, w.body)
+			}
+
+			if got := part.Header.Get("Content-Type"); got != "text/plain" {
+				t.Errorf("part %d Content-Type = %q, want %q", i, got, "text/plain")
+			}
+		}
+
+		if _, err := reader.NextPart(); err != io.EOF { //nolint:errorlint // io.EOF is returned unwrapped
+			t.Errorf("NextPart() error = %v, want %v", err, io.EOF)
+		}
+	})
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("origin calls = %d, want 1", got)
+	}
+}
@@ -4,14 +4,22 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 
 	"git.sr.ht/~jamesponddotco/xstd-go/xerrors"
 )
 
-// ErrInvalidResponse is returned when a response is invalid.
-const ErrInvalidResponse xerrors.Error = "invalid response"
+const (
+	// ErrInvalidResponse is returned when a response is invalid.
+	ErrInvalidResponse xerrors.Error = "invalid response"
+
+	// ErrBodyTooLarge is returned when a response body is larger than the
+	// maximum size allowed to be cached.
+	ErrBodyTooLarge xerrors.Error = "response body too large"
+)
 
 // SaveResponse saves an HTTP response and the request that generated it.
 func SaveResponse(resp *http.Response) (request, response []byte, err error) {
@@ -32,6 +40,146 @@ func SaveResponse(resp *http.Response) (request, response []byte, err error) {
 	return request, response, nil
 }
 
+// SaveResponseHead saves the status line and header of an HTTP response and
+// the request that generated it, leaving out the body, which the caller keeps
+// as is to avoid copying it. The saved header describes a body of the given
+// size, without transfer coding. Use LoadResponseHead to load the response.
+//
+// Responses to HEAD requests and 304 responses have no body, but describe the
+// length of the representation, so their Content-Length is kept as is.
+func SaveResponseHead(resp *http.Response, size int64) (request, head []byte, err error) {
+	if resp == nil || resp.Request == nil || resp.Request.URL == nil {
+		return nil, nil, fmt.Errorf("%w", ErrInvalidResponse)
+	}
+
+	request, err = httputil.DumpRequestOut(resp.Request, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+
+	withoutBody := *resp
+	withoutBody.Body = http.NoBody
+	withoutBody.ContentLength = size
+	withoutBody.TransferEncoding = nil
+	withoutBody.Trailer = nil
+
+	if length := declaredLength(resp); size == 0 && length > 0 && !hasBody(resp) {
+		withoutBody.ContentLength = length
+	}
+
+	head, err = httputil.DumpResponse(&withoutBody, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+
+	return request, head, nil
+}
+
+// hasBody reports whether the given response may have a body, which responses
+// to HEAD requests and 304 responses never have, following RFC 9110, Sections
+// 9.3.2 and 15.4.5.
+func hasBody(resp *http.Response) bool {
+	return resp.Request.Method != http.MethodHead && resp.StatusCode != http.StatusNotModified
+}
+
+// declaredLength returns the length of the representation described by the
+// given response, from its ContentLength or its Content-Length header, or -1
+// if it is unknown.
+func declaredLength(resp *http.Response) int64 {
+	if resp.ContentLength >= 0 {
+		return resp.ContentLength
+	}
+
+	length, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return -1
+	}
+
+	return length
+}
+
+// ReadBody reads the body of the given response, up to the given limit in
+// bytes, and replaces it with a replayable copy. Zero or a negative limit
+// indicates no limit.
+//
+// If the body is larger than the limit, reading stops as soon as the limit is
+// crossed and ErrBodyTooLarge is returned. The body is then replaced with one
+// returning the bytes read so far followed by the rest of the original body,
+// so the response is left intact for the caller.
+//
+// Bodies already read by ReadBody, or loaded by LoadResponseHead, are returned
+// without copying them, so the returned bytes must not be modified.
+func ReadBody(resp *http.Response, limit int64) ([]byte, error) {
+	if resp == nil || resp.Body == nil || resp.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if buffered, ok := resp.Body.(*bufferedBody); ok && buffered.Len() == len(buffered.data) {
+		if limit > 0 && int64(len(buffered.data)) > limit {
+			return nil, fmt.Errorf("%w", ErrBodyTooLarge)
+		}
+
+		return buffered.data, nil
+	}
+
+	reader := io.Reader(resp.Body)
+	if limit > 0 {
+		reader = io.LimitReader(resp.Body, limit+1)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err == nil && limit > 0 && int64(len(body)) > limit {
+		err = ErrBodyTooLarge
+	}
+
+	if err != nil {
+		resp.Body = readCloser{
+			Reader: io.MultiReader(bytes.NewReader(body), resp.Body),
+			Closer: resp.Body,
+		}
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	resp.Body.Close()
+	resp.Body = newBufferedBody(body)
+
+	return body, nil
+}
+
+// bufferedBody is a response body held in memory, whose bytes ReadBody returns
+// without copying them as long as it wasn't read from.
+type bufferedBody struct {
+	*bytes.Reader
+
+	// data is the whole body.
+	data []byte
+}
+
+// Compile-time check to ensure bufferedBody implements the io.ReadCloser
+// interface.
+var _ io.ReadCloser = (*bufferedBody)(nil)
+
+// newBufferedBody returns a bufferedBody reading the given bytes, which must
+// not be modified afterwards.
+func newBufferedBody(data []byte) *bufferedBody {
+	return &bufferedBody{
+		Reader: bytes.NewReader(data),
+		data:   data,
+	}
+}
+
+// Close implements the io.Closer interface.
+func (*bufferedBody) Close() error {
+	return nil
+}
+
+// readCloser combines an io.Reader with the io.Closer of another body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
 // LoadResponse loads an HTTP response from a saved request and response.
 func LoadResponse(request, response []byte) (*http.Response, error) {
 	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(request)))
@@ -46,3 +194,20 @@ func LoadResponse(request, response []byte) (*http.Response, error) {
 
 	return resp, nil
 }
+
+// LoadResponseHead loads an HTTP response from a request and response head
+// saved by SaveResponseHead, and the body saved along them, which is read
+// without being copied and must not be modified afterwards.
+func LoadResponseHead(request, head, body []byte) (*http.Response, error) {
+	resp, err := LoadResponse(request, head)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > 0 {
+		resp.Body = newBufferedBody(body)
+		resp.ContentLength = int64(len(body))
+	}
+
+	return resp, nil
+}
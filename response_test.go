@@ -200,3 +200,157 @@ func TestLoadResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestReadBody(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		body    string
+		limit   int64
+		wantErr error
+	}{
+		{
+			name:  "No limit",
+			body:  "Hello, World!",
+			limit: 0,
+		},
+		{
+			name:  "Within limit",
+			body:  "Hello, World!",
+			limit: 13,
+		},
+		{
+			name:    "Larger than limit",
+			body:    "Hello, World!",
+			limit:   5,
+			wantErr: pagecache.ErrBodyTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp := &http.Response{
+				Body:          io.NopCloser(strings.NewReader(tt.body)),
+				ContentLength: -1,
+			}
+
+			got, err := pagecache.ReadBody(resp, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadBody() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && string(got) != tt.body {
+				t.Errorf("ReadBody() = %q, want %q", got, tt.body)
+			}
+
+			// The caller must receive the whole body either way.
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != tt.body {
+				t.Errorf("body after ReadBody() = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestReadBody_Buffered(t *testing.T) {
+	t.Parallel()
+
+	resp := &http.Response{
+		Body:          io.NopCloser(strings.NewReader("Hello, World!")),
+		ContentLength: -1,
+	}
+
+	first, err := pagecache.ReadBody(resp, 0)
+	if err != nil {
+		t.Fatalf("ReadBody() unexpected error: %v", err)
+	}
+
+	// Reading the replayable copy again returns the same bytes, without
+	// copying them.
+	second, err := pagecache.ReadBody(resp, 0)
+	if err != nil {
+		t.Fatalf("second ReadBody() unexpected error: %v", err)
+	}
+
+	if &first[0] != &second[0] {
+		t.Error("second ReadBody() copied the body")
+	}
+
+	if _, err := pagecache.ReadBody(resp, 5); !errors.Is(err, pagecache.ErrBodyTooLarge) {
+		t.Errorf("ReadBody() over the limit error = %v, want %v", err, pagecache.ErrBodyTooLarge)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "Hello, World!" {
+		t.Errorf("body after ReadBody() = %q, want %q", body, "Hello, World!")
+	}
+}
+
+func TestSaveResponseHead(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+
+		// Flushing makes the response chunked, without Content-Length.
+		io.WriteString(w, "Hello, ") //nolint:errcheck // test server
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "World!") //nolint:errcheck // test server
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := pagecache.ReadBody(resp, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, head, err := pagecache.SaveResponseHead(resp, int64(len(body)))
+	if err != nil {
+		t.Fatalf("SaveResponseHead() unexpected error: %v", err)
+	}
+
+	if bytes.Contains(head, body) {
+		t.Errorf("SaveResponseHead() = %q, want the head only", head)
+	}
+
+	loaded, err := pagecache.LoadResponseHead(request, head, body)
+	if err != nil {
+		t.Fatalf("LoadResponseHead() unexpected error: %v", err)
+	}
+
+	got, err := io.ReadAll(loaded.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "Hello, World!" {
+		t.Errorf("loaded body = %q, want %q", got, "Hello, World!")
+	}
+
+	if loaded.ContentLength != int64(len(body)) || len(loaded.TransferEncoding) != 0 {
+		t.Errorf("loaded length = %d %v, want %d without transfer coding", loaded.ContentLength, loaded.TransferEncoding, len(body))
+	}
+
+	if etag := loaded.Header.Get("ETag"); etag != `"v1"` {
+		t.Errorf("loaded ETag = %q, want %q", etag, `"v1"`)
+	}
+}
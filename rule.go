@@ -2,10 +2,16 @@ package pagecache
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 
 	"git.sr.ht/~jamesponddotco/recache-go"
+	"git.sr.ht/~jamesponddotco/xstd-go/xerrors"
 )
 
+// ErrEmptyRule is returned when a rule has neither a URL nor a pattern set.
+const ErrEmptyRule xerrors.Error = "rule has no URL or pattern"
+
 const (
 	// BehaviorInclude means to include the URL in caching, even if it would be
 	// excluded by default.
@@ -55,3 +61,45 @@ func (r *Rule) Match(url string) bool {
 
 	return false
 }
+
+// compile compiles the rule's pattern, if any. The Must variants of
+// PatternFlag are treated as their non-panicking counterparts so that invalid
+// patterns are reported instead.
+func (r *Rule) compile() (*regexp.Regexp, error) {
+	if r.URL != "" {
+		return nil, nil //nolint:nilnil // exact URL rules have no regular expression
+	}
+
+	if r.Pattern == "" {
+		return nil, ErrEmptyRule
+	}
+
+	re, err := recache.Compile(r.Pattern, r.PatternFlag&^recache.FlagMust)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return re, nil
+}
+
+// RuleError describes a rule that failed validation.
+type RuleError struct {
+	// Rule is the invalid rule.
+	Rule *Rule
+
+	// Err is the underlying error.
+	Err error
+
+	// Index is the position of the rule in Policy.Rules.
+	Index int
+}
+
+// Error returns a string representation of the RuleError.
+func (re *RuleError) Error() string {
+	return fmt.Sprintf("rule %d: %v", re.Index, re.Err)
+}
+
+// Unwrap returns the underlying error of the RuleError.
+func (re *RuleError) Unwrap() error {
+	return re.Err
+}
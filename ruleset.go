@@ -0,0 +1,414 @@
+package pagecache
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"git.sr.ht/~jamesponddotco/recache-go"
+)
+
+// ruleSet is an immutable index of compiled rules that is safe for concurrent
+// use without locking.
+//
+// Rules are split by kind so that the cost of finding the first matching rule
+// stays roughly constant as the number of rules grows: exact URLs are kept in
+// a hash map, patterns that only anchor a literal prefix are kept in a trie,
+// and all remaining patterns are combined into a tree of regular expressions
+// that finds the first matching one in a logarithmic number of passes.
+type ruleSet struct {
+	// rules is a copy of the list of rules the set was compiled from, in
+	// order.
+	rules []*Rule
+
+	// exact maps exact URLs to the index of the first rule matching them.
+	exact map[string]int
+
+	// prefixes is a trie of literal URL prefixes.
+	prefixes *trieNode
+
+	// patterns holds the rules that are neither exact URLs nor literal
+	// prefixes, in order.
+	patterns []indexedRegexp
+
+	// tree combines every pattern to find the first matching one, or is nil
+	// if there are too few patterns to make it worthwhile.
+	tree *patternTree
+
+	// fallback holds the POSIX patterns, which cannot be combined with the
+	// others, in order.
+	fallback []indexedRegexp
+}
+
+// indexedRegexp is a compiled pattern and the index of its rule.
+type indexedRegexp struct {
+	regex *regexp.Regexp
+	index int
+}
+
+// patternTree is a binary tree of regular expressions over a list of
+// patterns. Each node combines the patterns of its subtree into a single
+// regular expression matching whenever any of them does, so the first
+// matching pattern is found by descending from the root, going left whenever
+// the left subtree matches.
+//
+// A regular expression matching the first rule in a single pass can't be
+// built, since alternations prefer the match starting first in the URL rather
+// than the first alternative; the tree needs one pass per level instead.
+type patternTree struct {
+	// regex matches whenever any pattern of the subtree does. For leaves,
+	// it is the pattern itself.
+	regex *regexp.Regexp
+
+	// left and right are the subtrees holding the first and second half of
+	// the patterns, or nil for leaves.
+	left, right *patternTree
+
+	// index is the index of the rule of a leaf.
+	index int
+}
+
+// newPatternTree builds a tree over the given patterns, which must not be
+// empty. It returns an error if a combined regular expression fails to
+// compile.
+func newPatternTree(patterns []indexedRegexp) (*patternTree, error) {
+	if len(patterns) == 1 {
+		return &patternTree{regex: patterns[0].regex, index: patterns[0].index}, nil
+	}
+
+	var builder strings.Builder
+
+	for i, p := range patterns {
+		if i > 0 {
+			builder.WriteByte('|')
+		}
+
+		builder.WriteString("(?:")
+		builder.WriteString(p.regex.String())
+		builder.WriteByte(')')
+	}
+
+	combined, err := regexp.Compile(builder.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	half := len(patterns) / 2
+
+	left, err := newPatternTree(patterns[:half])
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := newPatternTree(patterns[half:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &patternTree{regex: combined, left: left, right: right}, nil
+}
+
+// first returns the rule index of the first pattern matching the URL, or -1
+// if none does.
+func (n *patternTree) first(url string) int {
+	if !n.regex.MatchString(url) {
+		return -1
+	}
+
+	node := n
+
+	for node.left != nil {
+		if node.left.regex.MatchString(url) {
+			node = node.left
+		} else {
+			node = node.right
+		}
+	}
+
+	return node.index
+}
+
+// trieNode is a node of a byte-wise prefix trie.
+type trieNode struct {
+	children map[byte]*trieNode
+
+	// index is the index of the first rule whose prefix ends at this node, or
+	// -1 if none does.
+	index int
+}
+
+// newTrieNode returns an empty trie node.
+func newTrieNode() *trieNode {
+	return &trieNode{index: -1}
+}
+
+// insert adds a prefix to the trie, keeping the lowest rule index for it.
+func (n *trieNode) insert(prefix string, index int) {
+	node := n
+
+	for i := 0; i < len(prefix); i++ {
+		if node.children == nil {
+			node.children = make(map[byte]*trieNode)
+		}
+
+		child, ok := node.children[prefix[i]]
+		if !ok {
+			child = newTrieNode()
+			node.children[prefix[i]] = child
+		}
+
+		node = child
+	}
+
+	if node.index == -1 || index < node.index {
+		node.index = index
+	}
+}
+
+// lookup returns the lowest rule index among all prefixes of s in the trie, or
+// -1 if s has no prefix in it.
+func (n *trieNode) lookup(s string) int {
+	var (
+		node = n
+		best = node.index
+	)
+
+	for i := 0; i < len(s); i++ {
+		child, ok := node.children[s[i]]
+		if !ok {
+			break
+		}
+
+		node = child
+
+		if node.index != -1 && (best == -1 || node.index < best) {
+			best = node.index
+		}
+	}
+
+	return best
+}
+
+// compileRules compiles the given rules into a ruleSet. Invalid rules are kept
+// out of the set and never match, and an error is returned for each of them.
+func compileRules(rules []*Rule) (*ruleSet, []error) {
+	var (
+		set = &ruleSet{
+			rules:    append([]*Rule(nil), rules...),
+			exact:    make(map[string]int),
+			prefixes: newTrieNode(),
+		}
+		combinable []indexedRegexp
+		errs       []error
+	)
+
+	for i, rule := range rules {
+		if rule == nil {
+			errs = append(errs, &RuleError{Index: i, Err: ErrEmptyRule})
+
+			continue
+		}
+
+		re, err := rule.compile()
+		if err != nil {
+			errs = append(errs, &RuleError{Rule: rule, Index: i, Err: err})
+
+			continue
+		}
+
+		if re == nil {
+			set.addExact(rule.URL, i)
+
+			continue
+		}
+
+		literal, kind := literalPattern(rule)
+
+		switch {
+		case kind == literalExact:
+			set.addExact(literal, i)
+		case kind == literalPrefix:
+			set.prefixes.insert(literal, i)
+		case rule.PatternFlag&recache.FlagPOSIX != 0:
+			// POSIX patterns use a different syntax and cannot be safely
+			// combined with the others.
+			set.fallback = append(set.fallback, indexedRegexp{regex: re, index: i})
+		default:
+			combinable = append(combinable, indexedRegexp{regex: re, index: i})
+		}
+	}
+
+	set.combine(combinable)
+
+	sort.Slice(set.fallback, func(i, j int) bool {
+		return set.fallback[i].index < set.fallback[j].index
+	})
+
+	return set, errs
+}
+
+// addExact adds an exact URL to the set, keeping the lowest rule index for it.
+func (rs *ruleSet) addExact(url string, index int) {
+	if _, ok := rs.exact[url]; !ok {
+		rs.exact[url] = index
+	}
+}
+
+// combine builds a tree of regular expressions out of the given patterns,
+// which finds the first matching one without trying them one by one.
+func (rs *ruleSet) combine(patterns []indexedRegexp) {
+	rs.patterns = patterns
+
+	if len(patterns) < 2 {
+		return
+	}
+
+	tree, err := newPatternTree(patterns)
+	if err != nil {
+		// Should not happen since every pattern compiled on its own, but
+		// matching them one by one is still correct.
+		return
+	}
+
+	rs.tree = tree
+}
+
+// compiledFrom reports whether the set was compiled from the given rules, so
+// that replacing, adding or removing rules after Validate is detected. It is
+// safe to call on a nil set.
+func (rs *ruleSet) compiledFrom(rules []*Rule) bool {
+	if rs == nil || len(rs.rules) != len(rules) {
+		return false
+	}
+
+	for i, rule := range rules {
+		if rs.rules[i] != rule {
+			return false
+		}
+	}
+
+	return true
+}
+
+// match returns the first rule matching the URL, or nil if none does.
+func (rs *ruleSet) match(url string) *Rule {
+	best := -1
+
+	if index, ok := rs.exact[url]; ok {
+		best = index
+	}
+
+	if index := rs.prefixes.lookup(url); index != -1 && (best == -1 || index < best) {
+		best = index
+	}
+
+	if rs.tree == nil {
+		best = firstMatch(rs.patterns, url, best)
+	} else if index := rs.tree.first(url); index != -1 && (best == -1 || index < best) {
+		best = index
+	}
+
+	best = firstMatch(rs.fallback, url, best)
+
+	if best == -1 {
+		return nil
+	}
+
+	return rs.rules[best]
+}
+
+// firstMatch returns the index of the first pattern matching the URL if it is
+// lower than best, or best otherwise. Patterns must be sorted by index.
+func firstMatch(patterns []indexedRegexp, url string, best int) int {
+	for _, p := range patterns {
+		if best != -1 && p.index >= best {
+			break
+		}
+
+		if p.regex.MatchString(url) {
+			return p.index
+		}
+	}
+
+	return best
+}
+
+// literalKind describes how a pattern reduces to a literal string.
+type literalKind int
+
+const (
+	// literalNone means the pattern is not a plain literal.
+	literalNone literalKind = iota
+
+	// literalExact means the pattern matches exactly one string.
+	literalExact
+
+	// literalPrefix means the pattern matches every string with a given
+	// prefix.
+	literalPrefix
+)
+
+// literalPattern reports whether a rule's pattern is equivalent to an exact or
+// prefix string comparison, such as `^https://example\.com/$` or
+// `^https://example\.com/blog/`, and returns the literal if so.
+//
+// A trailing `.*` is treated as matching anything, since URLs never contain
+// newlines.
+func literalPattern(rule *Rule) (string, literalKind) {
+	flags := syntax.Perl
+	if rule.PatternFlag&recache.FlagPOSIX != 0 {
+		flags = syntax.POSIX
+	}
+
+	re, err := syntax.Parse(rule.Pattern, flags)
+	if err != nil {
+		return "", literalNone
+	}
+
+	re = re.Simplify()
+
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return "", literalNone
+	}
+
+	lit := re.Sub[1]
+	if lit.Op != syntax.OpLiteral || lit.Flags&syntax.FoldCase != 0 {
+		return "", literalNone
+	}
+
+	var (
+		literal = string(lit.Rune)
+		rest    = re.Sub[2:]
+		kind    = literalPrefix
+	)
+
+	if len(rest) > 0 && isMatchAnything(rest[0]) {
+		rest = rest[1:]
+
+		if len(rest) == 1 && rest[0].Op == syntax.OpEndText {
+			rest = rest[1:]
+		}
+	} else if len(rest) == 1 && rest[0].Op == syntax.OpEndText {
+		rest = rest[1:]
+		kind = literalExact
+	}
+
+	if len(rest) > 0 {
+		return "", literalNone
+	}
+
+	return literal, kind
+}
+
+// isMatchAnything reports whether re is `.*`.
+func isMatchAnything(re *syntax.Regexp) bool {
+	if re.Op != syntax.OpStar || len(re.Sub) != 1 {
+		return false
+	}
+
+	op := re.Sub[0].Op
+
+	return op == syntax.OpAnyChar || op == syntax.OpAnyCharNotNL
+}
@@ -0,0 +1,112 @@
+package pagecache
+
+import (
+	"net/http"
+)
+
+// DefaultStrippedHeaders returns the headers stripped from responses before
+// storage by DefaultPolicy: the hop-by-hop headers, which are meaningful only
+// for a single connection and must not be stored by caches, Set-Cookie, since
+// cookies set for one client must never be replayed to another, and
+// Strict-Transport-Security, which only applies to the connection it was
+// received on.
+func DefaultStrippedHeaders() map[string]struct{} { //nolint:revive // see Policy.AllowedStatusCodes
+	return map[string]struct{}{
+		"Connection":                {},
+		"Keep-Alive":                {},
+		"Proxy-Authenticate":        {},
+		"Proxy-Authorization":       {},
+		"Proxy-Connection":          {},
+		"Set-Cookie":                {},
+		"Strict-Transport-Security": {},
+		"Te":                        {},
+		"Trailer":                   {},
+		"Transfer-Encoding":         {},
+		"Upgrade":                   {},
+	}
+}
+
+// Sanitize returns the response that should be stored in the cache in place of
+// resp, with the headers listed in StrippedHeaders removed and, if
+// StripExcluded is true, the headers listed in ExcludedHeaders and the
+// Set-Cookie lines setting cookies listed in ExcludedCookies removed as well.
+//
+// If there is nothing to remove, resp is returned as is. Otherwise, a shallow
+// copy of resp with its own Header is returned, so the headers the caller sees
+// are never modified. The copy shares its Body with resp, so callers that read
+// the body of the copy, such as SaveResponse does, must assign it back to
+// resp.Body afterwards.
+func (p *Policy) Sanitize(resp *http.Response) *http.Response {
+	if resp == nil || !p.needsSanitizing(resp.Header) {
+		return resp
+	}
+
+	header := resp.Header.Clone()
+
+	for name := range p.StrippedHeaders {
+		header.Del(name)
+	}
+
+	if p.StripExcluded {
+		for name := range p.ExcludedHeaders {
+			header.Del(name)
+		}
+
+		p.stripExcludedCookies(header)
+	}
+
+	sanitized := *resp
+	sanitized.Header = header
+
+	return &sanitized
+}
+
+// needsSanitizing checks if any header would be removed by Sanitize.
+func (p *Policy) needsSanitizing(header http.Header) bool {
+	for name := range p.StrippedHeaders {
+		if _, ok := header[http.CanonicalHeaderKey(name)]; ok {
+			return true
+		}
+	}
+
+	if !p.StripExcluded {
+		return false
+	}
+
+	if !p.isCacheableHeaders(header) {
+		return true
+	}
+
+	return !p.isCacheableCookies((&http.Response{Header: header}).Cookies())
+}
+
+// stripExcludedCookies removes the Set-Cookie lines setting cookies listed in
+// ExcludedCookies from the given header.
+func (p *Policy) stripExcludedCookies(header http.Header) {
+	lines := header.Values("Set-Cookie")
+	if len(lines) == 0 {
+		return
+	}
+
+	kept := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		cookies := (&http.Response{Header: http.Header{"Set-Cookie": {line}}}).Cookies()
+
+		if len(cookies) > 0 {
+			if _, ok := p.ExcludedCookies[cookies[0].Name]; ok {
+				continue
+			}
+		}
+
+		kept = append(kept, line)
+	}
+
+	if len(kept) == 0 {
+		header.Del("Set-Cookie")
+
+		return
+	}
+
+	header["Set-Cookie"] = kept
+}
@@ -0,0 +1,134 @@
+package pagecache_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+func TestPolicy_Sanitize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy *pagecache.Policy
+		header http.Header
+		want   http.Header
+	}{
+		{
+			name:   "Nothing to strip",
+			policy: pagecache.DefaultPolicy(),
+			header: http.Header{
+				"Content-Type":  []string{"text/html"},
+				"Cache-Control": []string{"max-age=60"},
+			},
+			want: http.Header{
+				"Content-Type":  []string{"text/html"},
+				"Cache-Control": []string{"max-age=60"},
+			},
+		},
+		{
+			name:   "Cookies and HSTS",
+			policy: pagecache.DefaultPolicy(),
+			header: http.Header{
+				"Content-Type":              []string{"text/html"},
+				"Set-Cookie":                []string{"_ga=1", "theme=dark"},
+				"Strict-Transport-Security": []string{"max-age=0"},
+			},
+			want: http.Header{
+				"Content-Type": []string{"text/html"},
+			},
+		},
+		{
+			name:   "Hop-by-hop headers",
+			policy: pagecache.DefaultPolicy(),
+			header: http.Header{
+				"Connection":   []string{"keep-alive"},
+				"Keep-Alive":   []string{"timeout=5"},
+				"Content-Type": []string{"text/html"},
+			},
+			want: http.Header{
+				"Content-Type": []string{"text/html"},
+			},
+		},
+		{
+			name: "Custom stripped headers",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.StrippedHeaders["X-Debug"] = struct{}{}
+				return p
+			}(),
+			header: http.Header{
+				"Content-Type": []string{"text/html"},
+				"X-Debug":      []string{"1"},
+			},
+			want: http.Header{
+				"Content-Type": []string{"text/html"},
+			},
+		},
+		{
+			name: "Strip excluded headers and cookies",
+			policy: func() *pagecache.Policy {
+				// Keep the cookies that aren't excluded.
+				p := pagecache.DefaultPolicy()
+				delete(p.StrippedHeaders, "Set-Cookie")
+				p.StripExcluded = true
+				p.ExcludedHeaders["X-User"] = struct{}{}
+				p.ExcludedCookies["_ga"] = struct{}{}
+				return p
+			}(),
+			header: http.Header{
+				"Content-Type": []string{"text/html"},
+				"X-User":       []string{"alice"},
+				"Set-Cookie":   []string{"_ga=1; Path=/", "theme=dark", "sessionid=abc"},
+			},
+			want: http.Header{
+				"Content-Type": []string{"text/html"},
+				"Set-Cookie":   []string{"theme=dark"},
+			},
+		},
+		{
+			name: "Strip every cookie",
+			policy: func() *pagecache.Policy {
+				p := pagecache.DefaultPolicy()
+				p.StripExcluded = true
+				return p
+			}(),
+			header: http.Header{
+				"Content-Type": []string{"text/html"},
+				"Set-Cookie":   []string{"sessionid=abc"},
+			},
+			want: http.Header{
+				"Content-Type": []string{"text/html"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				original = tt.header.Clone()
+				resp     = &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     tt.header,
+				}
+			)
+
+			got := tt.policy.Sanitize(resp)
+
+			if !reflect.DeepEqual(got.Header, tt.want) {
+				t.Errorf("Sanitize() header = %v, want %v", got.Header, tt.want)
+			}
+
+			if !reflect.DeepEqual(resp.Header, original) {
+				t.Errorf("Sanitize() modified the original header: %v", resp.Header)
+			}
+		})
+	}
+}
@@ -0,0 +1,487 @@
+package pagecache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/xstd-go/xerrors"
+)
+
+// ErrInvalidContentRange is returned when a partial response has a missing or
+// invalid Content-Range header.
+const ErrInvalidContentRange xerrors.Error = "invalid content range"
+
+// segmentsKeySuffix is the extra information added to the cache key of a
+// request to get the key its partial responses are stored under.
+const segmentsKeySuffix = "segments"
+
+// segment is a contiguous range of bytes of a representation.
+type segment struct {
+	data  []byte
+	start int64
+}
+
+// end returns the offset of the byte following the segment.
+func (s segment) end() int64 {
+	return s.start + int64(len(s.data))
+}
+
+// segments holds the ranges of a representation received in partial
+// responses sharing the same strong validator, following RFC 9111, Section
+// 3.4.
+type segments struct {
+	// header is the header of the latest partial response, without the
+	// fields describing its content.
+	header http.Header
+
+	// etag is the strong entity tag shared by every segment.
+	etag string
+
+	// contentType is the media type of the representation.
+	contentType string
+
+	// parts are the segments, sorted by offset and never overlapping or
+	// adjacent.
+	parts []segment
+
+	// size is the size of the complete representation.
+	size int64
+}
+
+// parseSegments returns the segments held by the given 206 response, which may
+// hold a single range or a multipart/byteranges body. The response body is
+// consumed and replaced with a replayable copy.
+func parseSegments(resp *http.Response) (*segments, error) {
+	etag := resp.Header.Get("ETag")
+	if etag == "" || isWeakETag(etag) {
+		return nil, fmt.Errorf("%w: no strong entity tag", ErrUnsupported)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	s := &segments{
+		header:      resp.Header.Clone(),
+		etag:        etag,
+		contentType: resp.Header.Get("Content-Type"),
+		size:        -1,
+	}
+
+	for _, name := range []string{"Content-Range", "Content-Length", "Content-Type"} {
+		s.header.Del(name)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(s.contentType)
+	if err != nil || mediaType != "multipart/byteranges" {
+		if err := s.add(resp.Header.Get("Content-Range"), body); err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	}
+
+	s.contentType = ""
+
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF { //nolint:errorlint // io.EOF is returned unwrapped
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		if s.contentType == "" {
+			s.contentType = part.Header.Get("Content-Type")
+		}
+
+		if err := s.add(part.Header.Get("Content-Range"), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.parts) == 0 {
+		return nil, ErrInvalidContentRange
+	}
+
+	return s, nil
+}
+
+// add adds the given data, described by the given Content-Range header value,
+// to the segments.
+func (s *segments) add(contentRange string, data []byte) error {
+	r, size, err := parseContentRange(contentRange)
+	if err != nil {
+		return err
+	}
+
+	if r.length != int64(len(data)) || (s.size != -1 && s.size != size) {
+		return ErrInvalidContentRange
+	}
+
+	s.size = size
+	s.insert(segment{start: r.start, data: data})
+
+	return nil
+}
+
+// insert adds the given segment, merging it with the segments it overlaps or
+// is adjacent to.
+func (s *segments) insert(seg segment) {
+	parts := append(s.parts, seg) //nolint:gocritic // s.parts is replaced below
+
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].start < parts[j].start
+	})
+
+	merged := parts[:1]
+
+	for _, part := range parts[1:] {
+		last := &merged[len(merged)-1]
+
+		if part.start > last.end() {
+			merged = append(merged, part)
+
+			continue
+		}
+
+		if part.end() > last.end() {
+			data := make([]byte, 0, part.end()-last.start)
+			data = append(data, last.data...)
+			data = append(data, part.data[last.end()-part.start:]...)
+
+			last.data = data
+		}
+	}
+
+	s.parts = merged
+}
+
+// merge adds the segments of other, which must share the same validator and
+// size, to s.
+func (s *segments) merge(other *segments) {
+	for _, part := range other.parts {
+		s.insert(part)
+	}
+}
+
+// matches reports whether other holds ranges of the same representation as s.
+func (s *segments) matches(other *segments) bool {
+	return s.etag == other.etag && s.size == other.size
+}
+
+// complete reports whether the segments cover the whole representation.
+func (s *segments) complete() bool {
+	return len(s.parts) == 1 && s.parts[0].start == 0 && s.parts[0].end() == s.size
+}
+
+// read returns the bytes of the given range, if the segments hold all of them.
+func (s *segments) read(r byteRange) ([]byte, bool) {
+	for _, part := range s.parts {
+		if r.start >= part.start && r.start+r.length <= part.end() {
+			offset := r.start - part.start
+
+			return part.data[offset : offset+r.length], true
+		}
+	}
+
+	return nil, false
+}
+
+// response returns a response holding the segments, to be stored in the cache
+// on behalf of the given request: a 200 response if they cover the whole
+// representation and whole is true, or a 206 response holding one range or a
+// multipart/byteranges body otherwise.
+func (s *segments) response(req *http.Request, template *http.Response, whole bool) *http.Response {
+	resp := *template
+	resp.Request = req
+	resp.Header = s.header.Clone()
+
+	if s.contentType != "" {
+		resp.Header.Set("Content-Type", s.contentType)
+	}
+
+	if whole && s.complete() {
+		resp.StatusCode = http.StatusOK
+		resp.Status = strconv.Itoa(http.StatusOK) + " " + http.StatusText(http.StatusOK)
+		resp.Body = io.NopCloser(bytes.NewReader(s.parts[0].data))
+		resp.ContentLength = s.size
+		resp.Header.Set("Content-Length", strconv.FormatInt(s.size, 10))
+
+		return &resp
+	}
+
+	ranges := make([]byteRange, len(s.parts))
+	for i, part := range s.parts {
+		ranges[i] = byteRange{start: part.start, length: int64(len(part.data))}
+	}
+
+	partial, _ := rangeResponse(&resp, s.contentType, s.size, ranges, func(r byteRange) []byte {
+		data, _ := s.read(r)
+
+		return data
+	})
+
+	return partial
+}
+
+// parseContentRange parses a Content-Range header value of a partial
+// response, as defined by RFC 9110, Section 14.4. Only complete lengths that
+// are known are supported.
+func parseContentRange(value string) (r byteRange, size int64, err error) {
+	unit, spec, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok || !strings.EqualFold(unit, "bytes") {
+		return byteRange{}, 0, ErrInvalidContentRange
+	}
+
+	rangeSpec, sizeSpec, ok := strings.Cut(spec, "/")
+	if !ok {
+		return byteRange{}, 0, ErrInvalidContentRange
+	}
+
+	first, last, ok := strings.Cut(rangeSpec, "-")
+	if !ok {
+		return byteRange{}, 0, ErrInvalidContentRange
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return byteRange{}, 0, ErrInvalidContentRange
+	}
+
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return byteRange{}, 0, ErrInvalidContentRange
+	}
+
+	size, err = strconv.ParseInt(sizeSpec, 10, 64)
+	if err != nil || start < 0 || end < start || end >= size {
+		return byteRange{}, 0, ErrInvalidContentRange
+	}
+
+	return byteRange{start: start, length: end - start + 1}, size, nil
+}
+
+// segmentsKey returns the cache key the partial responses to the given request
+// with the given content coding are stored under, and a context carrying its
+// canonical form. Ranges of differently encoded representations are stored
+// apart, since their bytes can't be combined nor served to clients not
+// accepting their coding. The context is marked with WithSegments.
+func (t *Transport) segmentsKey(req *http.Request, coding string) (string, context.Context) {
+	extra := []string{segmentsKeySuffix}
+	if coding != "" {
+		extra = append(extra, coding)
+	}
+
+	ctx := WithSegments(WithRequest(WithCanonicalKey(req.Context(), t.canonicalKey(req, extra...)), req))
+
+	return t.key(req, extra...), ctx
+}
+
+// loadSegments returns the segments stored for the given request with the
+// given content coding, if any.
+func (t *Transport) loadSegments(req *http.Request, coding string) (*segments, *http.Response, bool) {
+	key, ctx := t.segmentsKey(req, coding)
+
+	resp, err := t.Cache.Get(ctx, key)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	if resp.StatusCode != http.StatusPartialContent || !MatchVariant(resp.Header, t.varyRequest(req)) {
+		resp.Body.Close()
+
+		return nil, nil, false
+	}
+
+	resp.Header.Del(VariantHeader)
+
+	s, err := parseSegments(resp)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	return s, resp, true
+}
+
+// storeSegments stores the ranges held by the given partial response to the
+// given request, combining them with the ranges already stored for the same
+// representation. Once the ranges cover the whole representation, it is
+// stored as a complete response under the key of the request instead, unless
+// its content coding can't be served to every client.
+func (t *Transport) storeSegments(req *http.Request, resp *http.Response, ttl time.Duration) error {
+	// Partial responses are read completely before being combined, so the
+	// size limit is enforced on the bytes actually received.
+	if _, err := ReadBody(resp, t.Cache.Policy().BodySizeLimit(resp)); err != nil {
+		return err
+	}
+
+	incoming, err := parseSegments(resp)
+	if err != nil {
+		return err
+	}
+
+	// Store the segments on behalf of a request for the whole representation,
+	// so the complete response can be served to any request once combined.
+	base := req.Clone(req.Context())
+	base.Header.Del("Range")
+	base.Header.Del("If-Range")
+
+	coding := rangeCoding(resp.Header)
+
+	if stored, _, ok := t.loadSegments(base, coding); ok && stored.matches(incoming) {
+		stored.merge(incoming)
+		incoming = stored
+	}
+
+	var (
+		// Complete encoded representations can only be served to every
+		// client if they are decoded on the fly, see Transport.StoreEncoded.
+		whole                    = coding == "" || (t.StoreEncoded && contentCoding(resp.Header) != "")
+		segmentsKey, segmentsCtx = t.segmentsKey(base, coding)
+		combined                 = t.withVariant(base, incoming.response(base, resp, whole))
+	)
+
+	if !whole || !incoming.complete() {
+		if err := t.Cache.Set(segmentsCtx, segmentsKey, combined, ttl); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		return nil
+	}
+
+	ctx := WithRequest(WithCanonicalKey(base.Context(), t.CanonicalKey(base)), base)
+
+	if err := t.Cache.Set(ctx, t.Key(base), combined, ttl); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	// The segments are redundant with the complete response now.
+	_ = t.Cache.Delete(segmentsCtx, segmentsKey) //nolint:errcheck // a stale copy is harmless
+
+	return nil
+}
+
+// serveSegments returns the response to the given range request served from
+// the stored segments, if they hold every requested range and the If-Range
+// precondition, if any, holds. Only segments with no content coding, or one
+// the request explicitly accepts, are served, since ranges of an encoded
+// representation can't be decoded.
+func (t *Transport) serveSegments(req *http.Request) (*http.Response, bool) {
+	value := req.Header.Get("Range")
+	if req.Method != http.MethodGet || value == "" {
+		return nil, false
+	}
+
+	for _, coding := range acceptedCodings(req.Header) {
+		if resp, ok := t.serveCodedSegments(req, value, coding); ok {
+			return resp, true
+		}
+	}
+
+	return nil, false
+}
+
+// serveCodedSegments returns the response to the given range request served
+// from the stored segments with the given content coding, as serveSegments.
+func (t *Transport) serveCodedSegments(req *http.Request, value, coding string) (*http.Response, bool) {
+	s, stored, ok := t.loadSegments(req, coding)
+	if !ok || !ifRangeMatches(req, stored) {
+		return nil, false
+	}
+
+	template := *stored
+	template.Request = req
+	template.Header = s.header
+
+	ranges, err := parseRange(value, s.size)
+	if err != nil {
+		if errors.Is(err, ErrUnsatisfiableRange) {
+			return unsatisfiableResponse(&template, s.size), true
+		}
+
+		return nil, false
+	}
+
+	for _, r := range ranges {
+		if _, ok := s.read(r); !ok {
+			return nil, false
+		}
+	}
+
+	return rangeResponse(&template, s.contentType, s.size, ranges, func(r byteRange) []byte {
+		data, _ := s.read(r)
+
+		return data
+	})
+}
+
+// rangeCoding returns the content codings of a partial response with the given
+// header, lowercased and separated by commas, or an empty string if it has
+// none.
+func rangeCoding(header http.Header) string {
+	var codings []string
+
+	for _, value := range header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "x-gzip" {
+				coding = codingGzip
+			}
+
+			if coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+
+	return strings.Join(codings, ",")
+}
+
+// acceptedCodings returns the content codings of the stored segments that can
+// answer a request with the given header, in order of preference: the codings
+// its Accept-Encoding lists explicitly with a non-zero quality value, then no
+// coding.
+func acceptedCodings(header http.Header) []string {
+	var codings []string
+
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, member := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(member, ";")
+
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "x-gzip" {
+				name = codingGzip
+			}
+
+			if name != "" && name != "*" && name != "identity" && qvalue(params) > 0 {
+				codings = append(codings, name)
+			}
+		}
+	}
+
+	return append(codings, "")
+}
@@ -0,0 +1,296 @@
+package pagecache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+// rangeOrigin is a test origin serving a representation with support for
+// range requests.
+type rangeOrigin struct {
+	server *httptest.Server
+	etag   string
+	calls  atomic.Int64
+	mu     sync.Mutex
+}
+
+func newRangeOrigin(t *testing.T, etag string) *rangeOrigin {
+	t.Helper()
+
+	origin := &rangeOrigin{etag: etag}
+
+	origin.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin.calls.Add(1)
+
+		origin.mu.Lock()
+		w.Header().Set("ETag", origin.etag)
+		origin.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain")
+
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+
+	t.Cleanup(origin.server.Close)
+
+	return origin
+}
+
+func (o *rangeOrigin) setETag(etag string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.etag = etag
+}
+
+func (o *rangeOrigin) get(t *testing.T, client *http.Client, rangeHeader string) (status int, body string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, o.server.URL+"/video", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(data)
+}
+
+func TestTransport_RoundTrip_Segments(t *testing.T) {
+	t.Parallel()
+
+	origin := newRangeOrigin(t, `"v1"`)
+
+	client := &http.Client{
+		Transport: pagecache.NewTransport(memorycachex.NewCache(nil, 0), origin.server.Client().Transport),
+	}
+
+	steps := []struct {
+		name       string
+		rangeValue string
+		wantStatus int
+		wantBody   string
+		wantCalls  int64
+	}{
+		{
+			name:       "First range from origin",
+			rangeValue: "bytes=0-3",
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "0123",
+			wantCalls:  1,
+		},
+		{
+			name:       "Range within stored segment",
+			rangeValue: "bytes=1-2",
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "12",
+			wantCalls:  1,
+		},
+		{
+			name:       "Second range from origin",
+			rangeValue: "bytes=6-",
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "6789",
+			wantCalls:  2,
+		},
+		{
+			name:       "Range across a gap",
+			rangeValue: "bytes=2-7",
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "234567",
+			wantCalls:  3,
+		},
+		{
+			name:       "Complete representation combined from segments",
+			wantStatus: http.StatusOK,
+			wantBody:   "0123456789",
+			wantCalls:  3,
+		},
+		{
+			name:       "Range from combined representation",
+			rangeValue: "bytes=-2",
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "89",
+			wantCalls:  3,
+		},
+	}
+
+	// The steps don't run in parallel, since each one depends on the cache
+	// state left by the previous ones.
+	for _, step := range steps {
+		step := step
+
+		t.Run(step.name, func(t *testing.T) {
+			status, body := origin.get(t, client, step.rangeValue)
+
+			if status != step.wantStatus || body != step.wantBody {
+				t.Errorf("got %d %q, want %d %q", status, body, step.wantStatus, step.wantBody)
+			}
+
+			if got := origin.calls.Load(); got != step.wantCalls {
+				t.Errorf("origin calls = %d, want %d", got, step.wantCalls)
+			}
+		})
+	}
+}
+
+func TestTransport_RoundTrip_SegmentsMultipart(t *testing.T) {
+	t.Parallel()
+
+	origin := newRangeOrigin(t, `"v1"`)
+
+	client := &http.Client{
+		Transport: pagecache.NewTransport(memorycachex.NewCache(nil, 0), origin.server.Client().Transport),
+	}
+
+	// The origin answers with a multipart/byteranges body, whose ranges are
+	// stored as separate segments.
+	if status, _ := origin.get(t, client, "bytes=0-1,8-9"); status != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", status, http.StatusPartialContent)
+	}
+
+	for _, rangeValue := range []string{"bytes=0-1", "bytes=8-9", "bytes=0-0,9-9"} {
+		if status, _ := origin.get(t, client, rangeValue); status != http.StatusPartialContent {
+			t.Errorf("%s: status = %d, want %d", rangeValue, status, http.StatusPartialContent)
+		}
+	}
+
+	if got := origin.calls.Load(); got != 1 {
+		t.Errorf("origin calls = %d, want 1", got)
+	}
+}
+
+func TestTransport_RoundTrip_SegmentsValidator(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		etag      string
+		newETag   string
+		wantCalls int64
+	}{
+		{
+			name:      "Same strong validator",
+			etag:      `"v1"`,
+			newETag:   `"v1"`,
+			wantCalls: 2,
+		},
+		{
+			name:      "Changed validator",
+			etag:      `"v1"`,
+			newETag:   `"v2"`,
+			wantCalls: 3,
+		},
+		{
+			name:      "Weak validator",
+			etag:      `W/"v1"`,
+			newETag:   `W/"v1"`,
+			wantCalls: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			origin := newRangeOrigin(t, tt.etag)
+
+			client := &http.Client{
+				Transport: pagecache.NewTransport(memorycachex.NewCache(nil, 0), origin.server.Client().Transport),
+			}
+
+			origin.get(t, client, "bytes=0-4")
+			origin.setETag(tt.newETag)
+			origin.get(t, client, "bytes=5-9")
+
+			// Only segments sharing a strong validator are combined into the
+			// complete representation.
+			if status, body := origin.get(t, client, ""); status != http.StatusOK || body != "0123456789" {
+				t.Errorf("got %d %q, want %d %q", status, body, http.StatusOK, "0123456789")
+			}
+
+			if got := origin.calls.Load(); got != tt.wantCalls {
+				t.Errorf("origin calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestTransport_RoundTrip_SegmentsInvalidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		wrap func(cache *memorycachex.MemoryCache) pagecache.Cache
+	}{
+		{
+			name: "URLPurger",
+			wrap: func(cache *memorycachex.MemoryCache) pagecache.Cache {
+				return cache
+			},
+		},
+		{
+			name: "Delete fallback",
+			wrap: func(cache *memorycachex.MemoryCache) pagecache.Cache {
+				return &plainCache{Cache: cache}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				origin = newRangeOrigin(t, `"v1"`)
+				client = &http.Client{
+					Transport: pagecache.NewTransport(tt.wrap(memorycachex.NewCache(nil, 0)), origin.server.Client().Transport),
+				}
+			)
+
+			origin.get(t, client, "bytes=0-3")
+
+			resp, err := client.Post(origin.server.URL+"/video", "text/plain", strings.NewReader("new"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			io.Copy(io.Discard, resp.Body) //nolint:errcheck // test
+			resp.Body.Close()
+
+			if status, body := origin.get(t, client, "bytes=0-3"); status != http.StatusPartialContent || body != "0123" {
+				t.Errorf("got %d %q, want %d %q", status, body, http.StatusPartialContent, "0123")
+			}
+
+			if got := origin.calls.Load(); got != 3 {
+				t.Errorf("origin calls = %d, want 3", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package pagecache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Tags returns the tags of the given response, used by Tagger implementations
+// to invalidate entries by tag. If TagFunc is set, it is used; otherwise tags
+// are read from the response headers by ResponseTags.
+func (p *Policy) Tags(resp *http.Response) []string {
+	if p.TagFunc != nil {
+		return p.TagFunc(resp)
+	}
+
+	return ResponseTags(resp.Header)
+}
+
+// ResponseTags returns the tags listed in the Surrogate-Key header, which
+// separates them by spaces, and in the Cache-Tag header, which separates them
+// by commas. Duplicate tags are returned once.
+func ResponseTags(header http.Header) []string {
+	var (
+		tags []string
+		seen = make(map[string]struct{})
+	)
+
+	add := func(tag string) {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return
+		}
+
+		if _, ok := seen[tag]; ok {
+			return
+		}
+
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
+	}
+
+	for _, value := range header.Values("Surrogate-Key") {
+		for _, tag := range strings.Fields(value) {
+			add(tag)
+		}
+	}
+
+	for _, value := range header.Values("Cache-Tag") {
+		for _, tag := range strings.Split(value, ",") {
+			add(tag)
+		}
+	}
+
+	return tags
+}
@@ -0,0 +1,91 @@
+package pagecache_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+)
+
+func TestResponseTags(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   []string
+	}{
+		{
+			name:   "No tags",
+			header: http.Header{},
+			want:   nil,
+		},
+		{
+			name: "Surrogate-Key",
+			header: http.Header{
+				"Surrogate-Key": []string{"article-1  author-2"},
+			},
+			want: []string{"article-1", "author-2"},
+		},
+		{
+			name: "Cache-Tag",
+			header: http.Header{
+				"Cache-Tag": []string{"article-1, author-2,"},
+			},
+			want: []string{"article-1", "author-2"},
+		},
+		{
+			name: "Both headers with duplicates",
+			header: http.Header{
+				"Surrogate-Key": []string{"article-1 home"},
+				"Cache-Tag":     []string{"home,author-2"},
+			},
+			want: []string{"article-1", "home", "author-2"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := pagecache.ResponseTags(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResponseTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Tags(t *testing.T) {
+	t.Parallel()
+
+	p := pagecache.DefaultPolicy()
+	p.TagFunc = func(resp *http.Response) []string {
+		return []string{"status-" + http.StatusText(resp.StatusCode)}
+	}
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Surrogate-Key": []string{"ignored"},
+		},
+	}
+
+	if got, want := p.Tags(resp), []string{"status-OK"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags() = %v, want %v", got, want)
+	}
+}
+
+func TestPurgeTags_Unsupported(t *testing.T) {
+	t.Parallel()
+
+	cache := pagecache.NewNamespace(nil, "test", 0)
+
+	if err := pagecache.PurgeTags(context.Background(), cache, "tag"); !errors.Is(err, pagecache.ErrUnsupported) {
+		t.Errorf("PurgeTags() = %v, want %v", err, pagecache.ErrUnsupported)
+	}
+}
@@ -0,0 +1,143 @@
+package pagecache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// cacheBody is an io.ReadCloser handing the body of a response to the caller
+// as it is read, while keeping a copy of it. The response is stored in the
+// cache only once the body was read to the end without errors and fits within
+// the size limit of the policy; it is discarded if the body is closed early,
+// fails, or grows past the limit.
+//
+// The copy is handed to the cache as is, so the body is only held in memory
+// once.
+type cacheBody struct {
+	// body is the original body of the response.
+	body io.ReadCloser
+
+	// commit stores the response with the given complete body. It is called
+	// without holding mu, since storing may take a while.
+	commit func(body []byte)
+
+	// buf holds the bytes read so far.
+	buf bytes.Buffer
+
+	// limit is the maximum size of the body allowed to be stored, in bytes.
+	// Zero or a negative value indicates no limit.
+	limit int64
+
+	// mu protects the fields below, since Close may be called concurrently
+	// with Read to abort it.
+	mu sync.Mutex
+
+	// done reports whether the body was committed or discarded.
+	done bool
+}
+
+// Compile-time check to ensure cacheBody implements the io.ReadCloser
+// interface.
+var _ io.ReadCloser = (*cacheBody)(nil)
+
+// Read implements the io.Reader interface.
+func (cb *cacheBody) Read(p []byte) (int, error) {
+	n, err := cb.body.Read(p)
+
+	if body, ok := cb.record(p[:n], err); ok {
+		cb.commit(body)
+	}
+
+	return n, err //nolint:wrapcheck // errors of the original body are returned as is
+}
+
+// record adds the given bytes, read from the original body along with the
+// given error, to the copy. It returns the complete body once the end of the
+// original body was reached, and the copy may be stored.
+func (cb *cacheBody) record(p []byte, err error) ([]byte, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.done {
+		return nil, false
+	}
+
+	if len(p) > 0 {
+		if cb.limit > 0 && int64(cb.buf.Len()+len(p)) > cb.limit {
+			cb.discard()
+
+			return nil, false
+		}
+
+		cb.buf.Write(p)
+	}
+
+	switch {
+	case errors.Is(err, io.EOF):
+		body := cb.buf.Bytes()
+
+		// The cache now owns the bytes.
+		cb.discard()
+
+		return body, true
+	case err != nil:
+		cb.discard()
+	}
+
+	return nil, false
+}
+
+// Close implements the io.Closer interface. Closing the body before reading it
+// to the end discards the copy.
+func (cb *cacheBody) Close() error {
+	cb.mu.Lock()
+	if !cb.done {
+		cb.discard()
+	}
+	cb.mu.Unlock()
+
+	return cb.body.Close() //nolint:wrapcheck // see Read
+}
+
+// discard drops the copy of the body. It must be called with mu held.
+func (cb *cacheBody) discard() {
+	cb.done = true
+	cb.buf = bytes.Buffer{}
+}
+
+// teeResponse replaces the body of the given response with one storing the
+// response under the given key once it has been read completely, following
+// the given policy. The stored copy keeps the header the response has now, so
+// later changes made for the caller, such as Cache-Status members, are not
+// stored.
+func (t *Transport) teeResponse(req *http.Request, resp *http.Response, policy *Policy, store func(resp *http.Response) error) {
+	stored := *resp
+	stored.Header = resp.Header.Clone()
+	stored.Trailer = nil
+
+	cb := &cacheBody{
+		body:  resp.Body,
+		limit: policy.BodySizeLimit(resp),
+		commit: func(body []byte) {
+			stored.Body = newBufferedBody(body)
+			stored.ContentLength = int64(len(body))
+			stored.Request = req
+
+			// Failing to store a response must not fail the request, so the
+			// error is only reported to the observer.
+			_ = store(&stored) //nolint:errcheck // see above
+		},
+	}
+
+	// Sizing the copy from Content-Length upfront avoids spare capacity, which
+	// would be kept along with the stored body. Only bounded bodies are sized
+	// upfront, so a bogus Content-Length can't allocate without limit.
+	if cb.limit > 0 && resp.ContentLength > 0 && resp.ContentLength <= cb.limit {
+		cb.buf.Grow(int(resp.ContentLength))
+	}
+
+	resp.Body = cb
+}
@@ -0,0 +1,235 @@
+package pagecache_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+func TestTransport_RoundTrip_StreamedBody(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat("0123456789", 100)
+
+	tests := []struct {
+		name        string
+		maxBodySize int64
+		// read is the number of bytes of the first response the client reads
+		// before closing it, or -1 to read it completely.
+		read      int
+		wantCalls int64
+	}{
+		{
+			name:      "Read completely",
+			read:      -1,
+			wantCalls: 1,
+		},
+		{
+			name:      "Closed early",
+			read:      10,
+			wantCalls: 2,
+		},
+		{
+			name:        "Larger than MaxBodySize",
+			maxBodySize: 100,
+			read:        -1,
+			wantCalls:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int64
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				calls.Add(1)
+
+				w.Header().Set("Content-Type", "text/plain")
+
+				// Flushing makes the response chunked, without Content-Length.
+				io.WriteString(w, body[:10]) //nolint:errcheck // test server
+				w.(http.Flusher).Flush()
+				io.WriteString(w, body[10:]) //nolint:errcheck // test server
+			}))
+			defer ts.Close()
+
+			policy := pagecache.DefaultPolicy()
+			policy.MaxBodySize = tt.maxBodySize
+
+			client := &http.Client{
+				Transport: pagecache.NewTransport(memorycachex.NewCache(policy, 0), ts.Client().Transport),
+			}
+
+			resp, err := client.Get(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.read < 0 {
+				got, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if string(got) != body {
+					t.Errorf("body has %d bytes, want %d", len(got), len(body))
+				}
+			} else if _, err := io.ReadFull(resp.Body, make([]byte, tt.read)); err != nil {
+				t.Fatal(err)
+			}
+
+			resp.Body.Close()
+
+			resp, err = client.Get(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp.Body.Close()
+
+			if string(got) != body {
+				t.Errorf("second body has %d bytes, want %d", len(got), len(body))
+			}
+
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("origin calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestTransport_RoundTrip_StreamedBodyIsNotBuffered(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "first") //nolint:errcheck // test server
+		w.(http.Flusher).Flush()
+
+		// The rest of the body is only sent once the client has received the
+		// first chunk.
+		<-release
+
+		io.WriteString(w, "second") //nolint:errcheck // test server
+	}))
+	defer ts.Close()
+
+	client := &http.Client{
+		Transport: pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport),
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		close(release)
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	first := make([]byte, len("first"))
+
+	_, err = io.ReadFull(resp.Body, first)
+
+	close(release)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(first) + string(rest); got != "firstsecond" {
+		t.Errorf("body = %q, want %q", got, "firstsecond")
+	}
+}
+
+// blockingCache blocks every Set until release is closed, signalling setting
+// once it starts.
+type blockingCache struct {
+	pagecache.Cache
+	setting chan struct{}
+	release chan struct{}
+}
+
+func (bc *blockingCache) Set(ctx context.Context, key string, resp *http.Response, duration time.Duration) error {
+	close(bc.setting)
+	<-bc.release
+
+	return bc.Cache.Set(ctx, key, resp, duration)
+}
+
+func TestTransport_RoundTrip_StreamedBodyCloseWhileStoring(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "page") //nolint:errcheck // test server
+	}))
+	defer ts.Close()
+
+	cache := &blockingCache{
+		Cache:   memorycachex.NewCache(nil, 0),
+		setting: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+
+	client := &http.Client{
+		Transport: pagecache.NewTransport(cache, ts.Client().Transport),
+	}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan error)
+
+	go func() {
+		_, err := io.ReadAll(resp.Body)
+		read <- err
+	}()
+
+	<-cache.setting
+
+	// Closing the body while the response is being stored must not wait for
+	// the store to finish.
+	closed := make(chan struct{})
+
+	go func() {
+		resp.Body.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("Close() blocked while storing the response")
+	}
+
+	close(cache.release)
+
+	if err := <-read; err != nil {
+		t.Fatalf("ReadAll() unexpected error: %v", err)
+	}
+
+	<-closed
+}
@@ -0,0 +1,365 @@
+package pagecache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go/internal/httputil"
+)
+
+// Transport is an http.RoundTripper that serves responses from a Cache when
+// possible, and stores cacheable responses from the underlying RoundTripper
+// in it otherwise. A Transport with an Observer must be closed with Close once
+// it is no longer used.
+type Transport struct {
+	// Cache is the cache used to store and retrieve responses.
+	Cache Cache
+
+	// Transport is the underlying RoundTripper used to make requests when a
+	// response is not cached. If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// KeyBuilder generates cache keys from requests. If nil, Key is used.
+	KeyBuilder *KeyBuilder
+
+	// Name is the name of the cache, used when generating cache keys. If
+	// empty, DefaultCacheName is used.
+	Name string
+
+	// Observer is notified of the hits, misses, stores and errors of the
+	// transport. Events are delivered asynchronously, in order for each key,
+	// by goroutines started on the first event, which Close stops. If nil, no
+	// events are emitted.
+	Observer *Observer
+
+	// StoreEncoded makes the transport ask the origin for gzip or deflate
+	// encoded responses, whatever the client accepts, and store them encoded,
+	// so a single entry serves every client. Responses are decoded on the fly
+	// for clients that don't accept their content coding. Cache keys should
+	// not include Accept-Encoding when it is set.
+	StoreEncoded bool
+
+	events     *Dispatcher
+	eventsOnce sync.Once
+}
+
+// Compile-time check to ensure Transport implements the http.RoundTripper
+// interface.
+var _ http.RoundTripper = (*Transport)(nil)
+
+// NewTransport creates a new Transport that caches responses from the given
+// RoundTripper in the given Cache. If an Observer is set, Close must be called
+// once the transport is no longer used to stop the goroutines delivering its
+// events.
+func NewTransport(cache Cache, transport http.RoundTripper) *Transport {
+	return &Transport{
+		Cache:     cache,
+		Transport: transport,
+		Name:      DefaultCacheName,
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+//
+// Every response carries a Cache-Status header member, identified by the cache
+// name, describing how the request was handled, after any members added by
+// caches closer to the origin.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy := t.Cache.Policy()
+
+	if _, ok := policy.AllowedMethods[req.Method]; !ok {
+		resp, err := t.roundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		t.invalidate(req, resp)
+
+		fwd := FwdBypass
+		if isUnsafeMethod(req.Method) {
+			fwd = FwdMethod
+		}
+
+		t.finish(policy, resp, &CacheStatus{Name: t.name(), Fwd: fwd, FwdStatus: resp.StatusCode})
+
+		return resp, nil
+	}
+
+	if !isCacheableCredentials(policy.CredentialMode, Credentials(req)) {
+		resp, err := t.roundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		t.finish(policy, resp, &CacheStatus{Name: t.name(), Fwd: FwdBypass, FwdStatus: resp.StatusCode})
+
+		return resp, nil
+	}
+
+	var (
+		key    = t.Key(req)
+		ctx    = WithRequest(WithCanonicalKey(req.Context(), t.CanonicalKey(req)), req)
+		url    = NormalizeURL(req.URL)
+		status = &CacheStatus{Name: t.name(), Key: key}
+		start  = time.Now()
+	)
+
+	noCache, noStore := requestDirectives(policy, req)
+
+	if noCache {
+		status.Fwd = FwdRequest
+	} else {
+		resp, hitKey, err := t.lookup(ctx, req, key)
+		if err == nil {
+			resp.Request = req
+			resp = t.decodeResponse(req, resp)
+
+			t.emit(Event{Key: hitKey, URL: url, Type: EventHit, Duration: time.Since(start)})
+
+			// Preconditions are evaluated before Range, following RFC 9110,
+			// Section 13.2.2.
+			if notModified(req, resp) {
+				resp = notModifiedResponse(resp)
+			} else if resp, err = serveRange(req, resp); err != nil {
+				t.emit(Event{Err: err, Key: key, URL: url, Type: EventError})
+
+				return nil, err
+			}
+
+			status.Hit = true
+			status.Key = hitKey
+
+			if info, err := Inspect(ctx, t.Cache, hitKey); err == nil && !info.Expiration.IsZero() {
+				ttl := time.Until(info.Expiration)
+				status.TTL = &ttl
+			}
+
+			t.finish(policy, resp, status)
+
+			return resp, nil
+		}
+
+		if resp, ok := t.serveSegments(req); ok {
+			t.emit(Event{Key: key, URL: url, Type: EventHit, Duration: time.Since(start)})
+
+			status.Hit = true
+
+			t.finish(policy, resp, status)
+
+			return resp, nil
+		}
+
+		status.Fwd = forwardReason(err)
+
+		if errors.Is(err, ErrCacheExpired) {
+			// Caches not recording revalidations are fine.
+			_ = RecordRevalidation(ctx, t.Cache) //nolint:errcheck // see above
+		}
+
+		t.emit(Event{Key: key, URL: url, Type: EventMiss, Duration: time.Since(start)})
+	}
+
+	resp, err := t.roundTrip(t.encodedRequest(req))
+	if err != nil {
+		t.emit(Event{Err: err, Key: key, URL: url, Type: EventError})
+
+		return nil, err
+	}
+
+	resp.Request = req
+	status.FwdStatus = resp.StatusCode
+
+	if !noStore && policy.IsCacheable(resp) {
+		ttl := policy.TTL(resp)
+
+		// Failing to store a response must not fail the request, so errors
+		// are only reported to the observer.
+		store := func(set func() error) error {
+			start := time.Now()
+
+			if err := set(); err != nil {
+				t.emit(Event{Err: err, Key: key, URL: url, Type: EventError})
+
+				return err
+			}
+
+			t.emit(Event{Key: key, URL: url, Type: EventStore, Duration: time.Since(start)})
+
+			return nil
+		}
+
+		var (
+			err      error
+			streamed bool
+		)
+
+		switch {
+		case resp.StatusCode == http.StatusPartialContent:
+			// Partial responses don't hold the complete representation, so
+			// they are stored as segments instead of under the key of the
+			// request.
+			err = store(func() error {
+				return t.storeSegments(req, resp, ttl)
+			})
+		case req.Method == http.MethodHead || resp.Body == nil || resp.Body == http.NoBody:
+			err = store(func() error {
+				stored := t.withVariant(req, resp)
+				defer func() { resp.Body = stored.Body }()
+
+				return t.Cache.Set(ctx, key, stored, ttl)
+			})
+		default:
+			// The body is handed to the caller as it is read, and the
+			// response is stored once it has been read completely, after
+			// Cache-Status was sent, so it can't report it as stored.
+			streamed = true
+
+			t.teeResponse(req, resp, policy, func(stored *http.Response) error {
+				return store(func() error {
+					return t.Cache.Set(ctx, key, t.withVariant(req, stored), ttl)
+				})
+			})
+		}
+
+		if err == nil {
+			status.Stored = !streamed
+
+			if ttl > 0 {
+				status.TTL = &ttl
+			}
+		}
+	}
+
+	if req.Method == http.MethodHead && !noStore {
+		t.updateFromHead(req, resp, policy)
+	}
+
+	resp = t.decodeResponse(req, resp)
+
+	t.finish(policy, resp, status)
+
+	return resp, nil
+}
+
+// finish prepares the given response to be returned to the client, removing
+// the targeted cache-control headers if the policy says so and adding the
+// given Cache-Status member.
+func (*Transport) finish(policy *Policy, resp *http.Response, status *CacheStatus) {
+	policy.StripTargeted(resp.Header)
+
+	AddCacheStatus(resp.Header, status)
+}
+
+// Key returns the cache key of the given request.
+func (t *Transport) Key(req *http.Request) string {
+	return t.key(req)
+}
+
+// CanonicalKey returns the canonical form of the cache key of the given
+// request, before hashing.
+func (t *Transport) CanonicalKey(req *http.Request) string {
+	return t.canonicalKey(req)
+}
+
+// key returns the cache key of the given request and extra information.
+func (t *Transport) key(req *http.Request, extra ...string) string {
+	if t.KeyBuilder != nil {
+		return t.KeyBuilder.Key(t.Name, req, extra...)
+	}
+
+	return Key(t.Name, req, extra...)
+}
+
+// canonicalKey returns the canonical form of the cache key of the given
+// request and extra information, before hashing.
+func (t *Transport) canonicalKey(req *http.Request, extra ...string) string {
+	if t.KeyBuilder != nil {
+		return t.KeyBuilder.Canonical(t.Name, req, extra...)
+	}
+
+	return CanonicalKey(t.Name, req, extra...)
+}
+
+// name returns the name identifying the cache in Cache-Status headers.
+func (t *Transport) name() string {
+	if t.Name == "" {
+		return DefaultCacheName
+	}
+
+	return t.Name
+}
+
+// Close delivers the pending events to the observer and stops the goroutines
+// delivering them. The transport must not be used after calling Close.
+func (t *Transport) Close() {
+	t.eventsOnce.Do(func() {})
+	t.events.Close()
+}
+
+// emit notifies the observer, if any, of the given event.
+func (t *Transport) emit(event Event) {
+	if t.Observer == nil {
+		return
+	}
+
+	t.eventsOnce.Do(func() {
+		t.events = NewDispatcher(t.Observer)
+	})
+
+	t.events.Emit(event)
+}
+
+// roundTrip makes the request using the underlying RoundTripper.
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return resp, nil
+}
+
+// requestDirectives reports whether the request forbids serving it from the
+// cache without validation, and whether it forbids storing its response,
+// following the request Cache-Control directives of RFC 9111, Section 5.2.1,
+// and the Pragma header of Section 5.4. Directives are ignored unless the
+// policy uses Cache-Control.
+func requestDirectives(policy *Policy, req *http.Request) (noCache, noStore bool) {
+	if !policy.UseCacheControl {
+		return false, false
+	}
+
+	noStore = httputil.HasDirective(req.Header, "no-store")
+	noCache = noStore || httputil.HasDirective(req.Header, "no-cache")
+
+	if !noCache && len(req.Header.Values("Cache-Control")) == 0 {
+		noCache = strings.Contains(strings.ToLower(req.Header.Get("Pragma")), "no-cache")
+	}
+
+	return noCache, noStore
+}
+
+// forwardReason returns the Cache-Status forward reason matching the given
+// Cache.Get error. The specific kinds of ErrCacheMiss are checked first, since
+// they also match it.
+func forwardReason(err error) string {
+	switch {
+	case errors.Is(err, ErrVaryMiss):
+		return FwdVaryMiss
+	case errors.Is(err, ErrCacheExpired):
+		return FwdStale
+	case errors.Is(err, ErrCacheMiss), errors.Is(err, ErrKeyNotFound):
+		return FwdURIMiss
+	default:
+		return FwdMiss
+	}
+}
@@ -0,0 +1,438 @@
+package pagecache_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+func TestTransport_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		method    string
+		paths     []string
+		builder   *pagecache.KeyBuilder
+		wantCalls int64
+	}{
+		{
+			name:      "Repeated GET",
+			method:    http.MethodGet,
+			paths:     []string{"/page", "/page", "/page"},
+			wantCalls: 1,
+		},
+		{
+			name:      "Different URLs",
+			method:    http.MethodGet,
+			paths:     []string{"/a", "/b", "/a"},
+			wantCalls: 2,
+		},
+		{
+			name:      "Uncacheable method",
+			method:    http.MethodPost,
+			paths:     []string{"/page", "/page"},
+			wantCalls: 2,
+		},
+		{
+			name:   "Key builder",
+			method: http.MethodGet,
+			paths:  []string{"/page?utm_source=a", "/page?utm_source=b", "/page"},
+			builder: &pagecache.KeyBuilder{
+				IgnoredParams: map[string]struct{}{"utm_source": {}},
+			},
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int64
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+
+				fmt.Fprintf(w, "response %d", n)
+			}))
+			defer ts.Close()
+
+			transport := pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport)
+			transport.KeyBuilder = tt.builder
+
+			client := &http.Client{Transport: transport}
+
+			for _, path := range tt.paths {
+				req, err := http.NewRequest(tt.method, ts.URL+path, http.NoBody)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("Do() unexpected error: %v", err)
+				}
+
+				if _, err = io.ReadAll(resp.Body); err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+
+				resp.Body.Close()
+			}
+
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("origin called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestTransport_RoundTrip_Invalidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		method      string
+		status      int
+		location    string
+		wantRefetch map[string]bool
+	}{
+		{
+			name:   "Successful POST",
+			method: http.MethodPost,
+			status: http.StatusOK,
+			wantRefetch: map[string]bool{
+				"/a": true,
+				"/b": false,
+				"/c": false,
+			},
+		},
+		{
+			name:     "Successful PUT with Location",
+			method:   http.MethodPut,
+			status:   http.StatusCreated,
+			location: "/b",
+			wantRefetch: map[string]bool{
+				"/a": true,
+				"/b": true,
+				"/c": false,
+			},
+		},
+		{
+			name:     "Successful DELETE with cross-origin Location",
+			method:   http.MethodDelete,
+			status:   http.StatusNoContent,
+			location: "https://other.example.com/b",
+			wantRefetch: map[string]bool{
+				"/a": true,
+				"/b": false,
+				"/c": false,
+			},
+		},
+		{
+			name:     "Failed PATCH",
+			method:   http.MethodPatch,
+			status:   http.StatusInternalServerError,
+			location: "/b",
+			wantRefetch: map[string]bool{
+				"/a": false,
+				"/b": false,
+				"/c": false,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu    sync.Mutex
+				calls = make(map[string]int)
+			)
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				calls[r.Method+" "+r.URL.Path]++
+				mu.Unlock()
+
+				if r.Method != http.MethodGet && r.Method != http.MethodHead {
+					if tt.location != "" {
+						w.Header().Set("Location", tt.location)
+					}
+
+					w.WriteHeader(tt.status)
+
+					return
+				}
+
+				fmt.Fprint(w, "page")
+			}))
+			defer ts.Close()
+
+			transport := pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport)
+			transport.KeyBuilder = &pagecache.KeyBuilder{Headers: []string{"Accept-Language"}}
+
+			client := &http.Client{Transport: transport}
+
+			do := func(method, path, language string) {
+				t.Helper()
+
+				req, err := http.NewRequest(method, ts.URL+path, http.NoBody)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				req.Header.Set("Accept-Language", language)
+
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("Do() unexpected error: %v", err)
+				}
+
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+
+			for _, path := range []string{"/a", "/b", "/c"} {
+				do(http.MethodGet, path, "en")
+				do(http.MethodGet, path, "pt")
+				do(http.MethodHead, path, "en")
+			}
+
+			do(tt.method, "/a", "en")
+
+			for _, path := range []string{"/a", "/b", "/c"} {
+				do(http.MethodGet, path, "en")
+				do(http.MethodGet, path, "pt")
+				do(http.MethodHead, path, "en")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			for path, refetch := range tt.wantRefetch {
+				want := 1
+				if refetch {
+					want = 2
+				}
+
+				if got := calls[http.MethodGet+" "+path]; got != 2*want {
+					t.Errorf("origin called %d times for GET %s, want %d", got, path, 2*want)
+				}
+
+				// HEAD requests are always answered from the stored GET
+				// response.
+				if got := calls[http.MethodHead+" "+path]; got != 0 {
+					t.Errorf("origin called %d times for HEAD %s, want 0", got, path)
+				}
+			}
+		})
+	}
+}
+
+func TestTransport_RoundTrip_CacheStatus(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Status", "Origin; fwd=uri-miss")
+		w.Header().Set("Vary", "Accept-Language")
+
+		fmt.Fprint(w, "page")
+	}))
+	defer ts.Close()
+
+	cache := memorycachex.NewCache(nil, 0)
+
+	transport := pagecache.NewTransport(cache, ts.Client().Transport)
+	transport.Name = "edge"
+	transport.KeyBuilder = &pagecache.KeyBuilder{Headers: []string{"Accept-Language"}}
+
+	newRequest := func(method, path string, header http.Header) *http.Request {
+		t.Helper()
+
+		req, err := http.NewRequest(method, ts.URL+path, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header = header
+
+		return req
+	}
+
+	// Store an already expired response for /stale.
+	stale := newRequest(http.MethodGet, "/stale", http.Header{})
+
+	resp, err := ts.Client().Do(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cache.Set(context.Background(), transport.Key(stale), resp, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want string
+	}{
+		{
+			name: "URI miss",
+			req:  newRequest(http.MethodGet, "/page", http.Header{"Accept-Language": {"en"}}),
+			want: `edge; fwd=uri-miss; fwd-status=200; ttl=3600; key=`,
+		},
+		{
+			name: "Hit",
+			req:  newRequest(http.MethodGet, "/page", http.Header{"Accept-Language": {"en"}}),
+			want: `edge; hit; ttl=`,
+		},
+		{
+			name: "Vary miss",
+			req:  newRequest(http.MethodGet, "/page", http.Header{"Accept-Language": {"fr"}}),
+			want: `edge; fwd=vary-miss; fwd-status=200; ttl=3600; key=`,
+		},
+		{
+			name: "Request no-cache",
+			req:  newRequest(http.MethodGet, "/page", http.Header{"Accept-Language": {"en"}, "Cache-Control": {"no-cache"}}),
+			want: `edge; fwd=request; fwd-status=200; ttl=3600; key=`,
+		},
+		{
+			name: "Request no-store",
+			req:  newRequest(http.MethodGet, "/page", http.Header{"Accept-Language": {"en"}, "Cache-Control": {"no-store"}}),
+			want: `edge; fwd=request; fwd-status=200; key=`,
+		},
+		{
+			name: "Stale",
+			req:  newRequest(http.MethodGet, "/stale", http.Header{}),
+			want: `edge; fwd=stale; fwd-status=200; ttl=3600; key=`,
+		},
+		{
+			name: "HEAD stored before returning",
+			req:  newRequest(http.MethodHead, "/head", http.Header{}),
+			want: `edge; fwd=uri-miss; fwd-status=200; ttl=3600; stored; key=`,
+		},
+		{
+			name: "Unsafe method",
+			req:  newRequest(http.MethodPost, "/page", http.Header{}),
+			want: `edge; fwd=method; fwd-status=200`,
+		},
+		{
+			name: "Bypass",
+			req:  newRequest(http.MethodOptions, "/page", http.Header{}),
+			want: `edge; fwd=bypass; fwd-status=200`,
+		},
+	}
+
+	// The cases don't run in parallel, since each one depends on the cache
+	// state left by the previous ones.
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := transport.RoundTrip(tt.req)
+			if err != nil {
+				t.Fatalf("RoundTrip() unexpected error: %v", err)
+			}
+
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			got := resp.Header.Values("Cache-Status")
+			if len(got) != 2 || got[0] != "Origin; fwd=uri-miss" || !strings.HasPrefix(got[1], tt.want) {
+				t.Errorf("Cache-Status = %q, want [%q %q...]", got, "Origin; fwd=uri-miss", tt.want)
+			}
+		})
+	}
+}
+
+func TestTransport_RoundTrip_TargetedHeaders(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		strip     bool
+		wantCalls int64
+		wantCDN   string
+	}{
+		{
+			name:      "Kept",
+			wantCalls: 1,
+			wantCDN:   "max-age=600",
+		},
+		{
+			name:      "Stripped",
+			strip:     true,
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var calls atomic.Int64
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				calls.Add(1)
+
+				// Clients must not cache, but the edge cache may.
+				w.Header().Set("Cache-Control", "no-store")
+				w.Header().Set("CDN-Cache-Control", "max-age=600")
+
+				fmt.Fprint(w, "page")
+			}))
+			defer ts.Close()
+
+			policy := pagecache.DefaultPolicy()
+			policy.TargetedHeaders = []string{"CDN-Cache-Control"}
+			policy.StripTargetedHeaders = tt.strip
+
+			client := &http.Client{
+				Transport: pagecache.NewTransport(memorycachex.NewCache(policy, 0), ts.Client().Transport),
+			}
+
+			for i := 0; i < 2; i++ {
+				resp, err := client.Get(ts.URL + "/page")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+
+				if got := resp.Header.Get("CDN-Cache-Control"); got != tt.wantCDN {
+					t.Errorf("CDN-Cache-Control = %q, want %q", got, tt.wantCDN)
+				}
+
+				if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+					t.Errorf("Cache-Control = %q, want %q", got, "no-store")
+				}
+			}
+
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("origin calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
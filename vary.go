@@ -0,0 +1,146 @@
+package pagecache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// VariantHeader is the header under which Transport stores, along with a
+// response whose Vary header lists request headers, the values those headers
+// had in the request the response was stored for. Stored responses are only
+// served to requests with the same values, following RFC 9111, Section 4.1,
+// and the header is removed before serving them.
+//
+// A single response is stored per cache key, so storing a response for
+// another variant replaces the previous one.
+const VariantHeader = "Pagecache-Variant"
+
+// Variant returns the canonical form of the values the given request has for
+// the request headers listed in the given Vary header, or an empty string if
+// it lists none. It returns false if Vary is "*", since a response varying on
+// it never matches another request.
+func Variant(header http.Header, req *http.Request) (string, bool) {
+	names, ok := varyFields(header)
+	if !ok {
+		return "", false
+	}
+
+	values := make([]string, 0, len(names))
+
+	for _, name := range names {
+		values = append(values, name+"="+strconv.QuoteToASCII(strings.Join(req.Header.Values(name), ", ")))
+	}
+
+	return strings.Join(values, ", "), true
+}
+
+// MatchVariant reports whether a stored response with the given header may be
+// served for the given request according to its Vary header: the values
+// recorded in VariantHeader must be those of the request. Responses varying on
+// "*", and responses listing request headers in Vary without recording their
+// values, never match.
+func MatchVariant(header http.Header, req *http.Request) bool {
+	variant, ok := Variant(header, req)
+	if !ok {
+		return false
+	}
+
+	return variant == "" || header.Get(VariantHeader) == variant
+}
+
+// varyFields returns the canonical names of the request headers listed in the
+// given Vary header, in order and without duplicates. It returns false if Vary
+// is "*".
+func varyFields(header http.Header) ([]string, bool) {
+	var names []string
+
+	for _, value := range header.Values("Vary") {
+		for _, member := range strings.Split(value, ",") {
+			member = strings.TrimSpace(member)
+
+			switch member {
+			case "":
+				continue
+			case "*":
+				return nil, false
+			}
+
+			name := http.CanonicalHeaderKey(member)
+			if !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names, true
+}
+
+// containsString reports whether the given list contains the given string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// varyRequest returns the request whose header values select the variant of
+// the responses to the given request. It is the request itself, unless
+// responses are stored encoded, in which case Accept-Encoding is set as it is
+// forwarded to the origin, since every client is served from the same encoded
+// response. See Transport.StoreEncoded.
+func (t *Transport) varyRequest(req *http.Request) *http.Request {
+	if !t.StoreEncoded {
+		return req
+	}
+
+	vary := req.Clone(req.Context())
+	vary.Header.Set("Accept-Encoding", codingGzip+", "+codingDeflate)
+
+	return vary
+}
+
+// withVariant returns the response to store for the given request: the
+// response itself if its Vary header lists no request header, or a shallow
+// copy with its own Header recording their values in VariantHeader otherwise.
+// Like with Policy.Sanitize, callers reading the body of the copy must assign
+// it back to the response afterwards.
+func (t *Transport) withVariant(req *http.Request, resp *http.Response) *http.Response {
+	if len(resp.Header.Values("Vary")) == 0 {
+		return resp
+	}
+
+	variant, ok := Variant(resp.Header, t.varyRequest(req))
+	if !ok || variant == "" {
+		return resp
+	}
+
+	stored := *resp
+	stored.Header = resp.Header.Clone()
+	stored.Header.Set(VariantHeader, variant)
+
+	return &stored
+}
+
+// matchVariant reports whether the given stored response may be served for the
+// given request according to its Vary header and, unless responses are stored
+// encoded, its content coding, which the request must accept. VariantHeader
+// is removed from the response either way.
+func (t *Transport) matchVariant(req *http.Request, resp *http.Response) bool {
+	defer resp.Header.Del(VariantHeader)
+
+	if !t.StoreEncoded {
+		if coding := contentCoding(resp.Header); coding != "" && !acceptsEncoding(req.Header, coding) {
+			return false
+		}
+	}
+
+	if len(resp.Header.Values("Vary")) == 0 {
+		return true
+	}
+
+	return MatchVariant(resp.Header, t.varyRequest(req))
+}
@@ -0,0 +1,209 @@
+package pagecache_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~jamesponddotco/pagecache-go"
+	"git.sr.ht/~jamesponddotco/pagecache-go/memorycachex"
+)
+
+func TestVariant(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		vary   []string
+		header http.Header
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "No Vary",
+			header: http.Header{"Accept-Language": {"en"}},
+			want:   "",
+			wantOK: true,
+		},
+		{
+			name:   "Single field",
+			vary:   []string{"accept-language"},
+			header: http.Header{"Accept-Language": {"en"}},
+			want:   `Accept-Language="en"`,
+			wantOK: true,
+		},
+		{
+			name:   "Several fields and duplicates",
+			vary:   []string{"Accept-Language, Accept-Encoding", "accept-language"},
+			header: http.Header{"Accept-Language": {"en", "fr"}},
+			want:   `Accept-Language="en, fr", Accept-Encoding=""`,
+			wantOK: true,
+		},
+		{
+			name:   "Wildcard",
+			vary:   []string{"Accept-Language, *"},
+			header: http.Header{},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, err := http.NewRequest(http.MethodGet, "http://example.com/", http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req.Header = tt.header
+
+			got, ok := pagecache.Variant(http.Header{"Vary": tt.vary}, req)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Variant() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTransport_RoundTrip_Vary(t *testing.T) {
+	t.Parallel()
+
+	gzipped := string(encode(t, "gzip", "page"))
+
+	// encoded answers gzip clients with a gzip encoded body, listing
+	// Accept-Encoding in Vary if vary is true.
+	encoded := func(vary bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if vary {
+				w.Header().Set("Vary", "Accept-Encoding")
+			}
+
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				io.WriteString(w, "page")
+
+				return
+			}
+
+			w.Header().Set("Content-Encoding", "gzip")
+			io.WriteString(w, gzipped)
+		}
+	}
+
+	type step struct {
+		header   http.Header
+		wantBody string
+		wantHit  bool
+	}
+
+	tests := []struct {
+		name         string
+		handler      http.HandlerFunc
+		storeEncoded bool
+		steps        []step
+	}{
+		{
+			name: "Accept-Language",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Vary", "Accept-Language")
+				io.WriteString(w, "page "+r.Header.Get("Accept-Language"))
+			},
+			steps: []step{
+				{header: http.Header{"Accept-Language": {"en"}}, wantBody: "page en"},
+				{header: http.Header{"Accept-Language": {"en"}}, wantBody: "page en", wantHit: true},
+				{header: http.Header{"Accept-Language": {"fr"}}, wantBody: "page fr"},
+				{header: http.Header{"Accept-Language": {"fr"}}, wantBody: "page fr", wantHit: true},
+			},
+		},
+		{
+			name: "Wildcard",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Vary", "*")
+				io.WriteString(w, "page")
+			},
+			steps: []step{
+				{header: http.Header{}, wantBody: "page"},
+				{header: http.Header{}, wantBody: "page"},
+			},
+		},
+		{
+			name:    "Encoded response varying on Accept-Encoding",
+			handler: encoded(true),
+			steps: []step{
+				{header: http.Header{"Accept-Encoding": {"gzip"}}, wantBody: gzipped},
+				{header: http.Header{"Accept-Encoding": {"gzip"}}, wantBody: gzipped, wantHit: true},
+				{header: http.Header{}, wantBody: "page"},
+				{header: http.Header{}, wantBody: "page", wantHit: true},
+			},
+		},
+		{
+			name:    "Encoded response without Vary",
+			handler: encoded(false),
+			steps: []step{
+				{header: http.Header{"Accept-Encoding": {"gzip"}}, wantBody: gzipped},
+				{header: http.Header{}, wantBody: "page"},
+			},
+		},
+		{
+			name:         "Encoded response stored encoded",
+			handler:      encoded(true),
+			storeEncoded: true,
+			steps: []step{
+				{header: http.Header{"Accept-Encoding": {"gzip"}}, wantBody: gzipped},
+				{header: http.Header{}, wantBody: "page", wantHit: true},
+				{header: http.Header{"Accept-Encoding": {"gzip"}}, wantBody: gzipped, wantHit: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+
+			transport := pagecache.NewTransport(memorycachex.NewCache(nil, 0), ts.Client().Transport)
+			transport.StoreEncoded = tt.storeEncoded
+
+			for i, step := range tt.steps {
+				req, err := http.NewRequest(http.MethodGet, ts.URL+"/page", http.NoBody)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				req.Header = step.header
+
+				resp, err := transport.RoundTrip(req)
+				if err != nil {
+					t.Fatalf("RoundTrip() unexpected error: %v", err)
+				}
+
+				body, err := io.ReadAll(resp.Body)
+				if err != nil {
+					t.Fatalf("failed to read body: %v", err)
+				}
+
+				resp.Body.Close()
+
+				if string(body) != step.wantBody {
+					t.Errorf("request %d: body = %q, want %q", i, body, step.wantBody)
+				}
+
+				if hit := strings.Contains(resp.Header.Get("Cache-Status"), "; hit"); hit != step.wantHit {
+					t.Errorf("request %d: hit = %v, want %v (Cache-Status %q)", i, hit, step.wantHit, resp.Header.Get("Cache-Status"))
+				}
+
+				if got := resp.Header.Get(pagecache.VariantHeader); got != "" {
+					t.Errorf("request %d: %s = %q, want none", i, pagecache.VariantHeader, got)
+				}
+			}
+		})
+	}
+}